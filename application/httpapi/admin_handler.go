@@ -1,12 +1,17 @@
 package httpapi
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
 )
 
 type spaHandler struct {
@@ -62,3 +67,42 @@ var adminAPIReverse = func(writer http.ResponseWriter, request *http.Request) {
 	//request.URL.Scheme = targetUrl.Scheme
 	httputil.NewSingleHostReverseProxy(targetUrl).ServeHTTP(writer, request)
 }
+
+// requireAdmin abort the request if the current user is not an admin
+func requireAdmin(context *haruka.Context) (*database.User, bool) {
+	rawUser := context.Param["user"]
+	if rawUser == nil {
+		AbortError(context, errors.New("user not found"), http.StatusForbidden)
+		return nil, false
+	}
+	user := rawUser.(*database.User)
+	if !service.IsAdmin(user) {
+		AbortError(context, service.PermissionDenied, http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+var getSigningKeyListHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	keys, err := service.GetSigningKeyList()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponseWithData(context, NewSigningKeyTemplateList(keys))
+}
+
+var rotateSigningKeyHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	err := service.RotateSigningKey()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponse(context)
+}
@@ -0,0 +1,38 @@
+package httpapi
+
+import "github.com/projectxpolaris/youauth/database"
+
+type SigningKeyTemplate struct {
+	Id          uint   `json:"id"`
+	Kid         string `json:"kid"`
+	Algorithm   string `json:"algorithm"`
+	State       string `json:"state"`
+	CreateAt    string `json:"createAt"`
+	ActivatedAt string `json:"activatedAt,omitempty"`
+	RetiredAt   string `json:"retiredAt,omitempty"`
+}
+
+func NewSigningKeyTemplate(key *database.SigningKey) SigningKeyTemplate {
+	template := SigningKeyTemplate{
+		Id:        key.ID,
+		Kid:       key.Kid,
+		Algorithm: key.Algorithm,
+		State:     key.State,
+		CreateAt:  key.CreatedAt.Format(timeFormat),
+	}
+	if key.ActivatedAt != nil {
+		template.ActivatedAt = key.ActivatedAt.Format(timeFormat)
+	}
+	if key.RetiredAt != nil {
+		template.RetiredAt = key.RetiredAt.Format(timeFormat)
+	}
+	return template
+}
+
+func NewSigningKeyTemplateList(keys []*database.SigningKey) []SigningKeyTemplate {
+	templates := make([]SigningKeyTemplate, 0)
+	for _, key := range keys {
+		templates = append(templates, NewSigningKeyTemplate(key))
+	}
+	return templates
+}
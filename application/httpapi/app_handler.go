@@ -1,6 +1,7 @@
 package httpapi
 
 import (
+	"encoding/json"
 	"github.com/allentom/haruka"
 	"github.com/projectxpolaris/youauth/database"
 	"github.com/projectxpolaris/youauth/service"
@@ -8,8 +9,22 @@ import (
 )
 
 type CreateAppData struct {
-	Name     string `json:"name"`
-	Callback string `json:"callback"`
+	Name                    string          `json:"name"`
+	Callback                string          `json:"callback"`
+	RedirectUris            []string        `json:"redirectUris"`
+	AllowLoopbackPort       bool            `json:"allowLoopbackPort"`
+	RequirePkce             bool            `json:"requirePkce"`
+	RequirePar              bool            `json:"requirePar"`
+	TokenEndpointAuthMethod string          `json:"tokenEndpointAuthMethod"`
+	AllowedScopes           []string        `json:"allowedScopes"`
+	ClientCredentialsScopes []string        `json:"clientCredentialsScopes"`
+	FirstParty              bool            `json:"firstParty"`
+	PostLogoutRedirectUris  []string        `json:"postLogoutRedirectUris"`
+	FrontchannelLogoutUri   string          `json:"frontchannelLogoutUri"`
+	BackchannelLogoutUri    string          `json:"backchannelLogoutUri"`
+	TokenExchangeAudiences  []string        `json:"tokenExchangeAudiences"`
+	Jwks                    json.RawMessage `json:"jwks"`
+	JwksUri                 string          `json:"jwksUri"`
 }
 
 var createAppHandler haruka.RequestHandler = func(context *haruka.Context) {
@@ -20,7 +35,29 @@ var createAppHandler haruka.RequestHandler = func(context *haruka.Context) {
 		AbortError(context, err, http.StatusBadRequest)
 		return
 	}
-	app, err := service.CreateApp(requestBody.Name, requestBody.Callback, user.ID)
+	// only admins can exempt an app from the consent page or let it act on behalf of users
+	if (requestBody.FirstParty || len(requestBody.TokenExchangeAudiences) > 0) && !service.IsAdmin(user) {
+		AbortError(context, service.PermissionDenied, http.StatusForbidden)
+		return
+	}
+	app, err := service.CreateApp(service.CreateAppOption{
+		Name:                    requestBody.Name,
+		Callback:                requestBody.Callback,
+		RedirectUris:            requestBody.RedirectUris,
+		AllowLoopbackPort:       requestBody.AllowLoopbackPort,
+		RequirePkce:             requestBody.RequirePkce,
+		RequirePar:              requestBody.RequirePar,
+		TokenEndpointAuthMethod: requestBody.TokenEndpointAuthMethod,
+		AllowedScopes:           requestBody.AllowedScopes,
+		ClientCredentialsScopes: requestBody.ClientCredentialsScopes,
+		FirstParty:              requestBody.FirstParty,
+		PostLogoutRedirectUris:  requestBody.PostLogoutRedirectUris,
+		FrontchannelLogoutUri:   requestBody.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    requestBody.BackchannelLogoutUri,
+		TokenExchangeAudiences:  requestBody.TokenExchangeAudiences,
+		Jwks:                    string(requestBody.Jwks),
+		JwksUri:                 requestBody.JwksUri,
+	}, user.ID)
 	if err != nil {
 		AbortError(context, err, http.StatusInternalServerError)
 		return
@@ -53,6 +90,75 @@ var getAppListHandler haruka.RequestHandler = func(context *haruka.Context) {
 	MakeListResponse(context, data, count, queryBuilder.Page, queryBuilder.PageSize)
 }
 
+type UpdateAppData struct {
+	Name                    *string         `json:"name"`
+	Callback                *string         `json:"callback"`
+	RedirectUris            []string        `json:"redirectUris"`
+	AllowLoopbackPort       *bool           `json:"allowLoopbackPort"`
+	RequirePkce             *bool           `json:"requirePkce"`
+	RequirePar              *bool           `json:"requirePar"`
+	TokenEndpointAuthMethod *string         `json:"tokenEndpointAuthMethod"`
+	AllowedScopes           []string        `json:"allowedScopes"`
+	ClientCredentialsScopes []string        `json:"clientCredentialsScopes"`
+	FirstParty              *bool           `json:"firstParty"`
+	PostLogoutRedirectUris  []string        `json:"postLogoutRedirectUris"`
+	FrontchannelLogoutUri   *string         `json:"frontchannelLogoutUri"`
+	BackchannelLogoutUri    *string         `json:"backchannelLogoutUri"`
+	TokenExchangeAudiences  []string        `json:"tokenExchangeAudiences"`
+	Jwks                    json.RawMessage `json:"jwks"`
+	JwksUri                 *string         `json:"jwksUri"`
+}
+
+var updateAppHandler haruka.RequestHandler = func(context *haruka.Context) {
+	user := context.Param["user"].(*database.User)
+	appId := context.GetPathParameterAsString("appid")
+	var requestBody UpdateAppData
+	err := context.ParseJson(&requestBody)
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	if (requestBody.FirstParty != nil || requestBody.TokenExchangeAudiences != nil) && !service.IsAdmin(user) {
+		AbortError(context, service.PermissionDenied, http.StatusForbidden)
+		return
+	}
+	app, err := service.UpdateApp(appId, user.ID, service.IsAdmin(user), service.UpdateAppOption{
+		Name:                    requestBody.Name,
+		Callback:                requestBody.Callback,
+		RedirectUris:            requestBody.RedirectUris,
+		AllowLoopbackPort:       requestBody.AllowLoopbackPort,
+		RequirePkce:             requestBody.RequirePkce,
+		RequirePar:              requestBody.RequirePar,
+		TokenEndpointAuthMethod: requestBody.TokenEndpointAuthMethod,
+		AllowedScopes:           requestBody.AllowedScopes,
+		ClientCredentialsScopes: requestBody.ClientCredentialsScopes,
+		FirstParty:              requestBody.FirstParty,
+		PostLogoutRedirectUris:  requestBody.PostLogoutRedirectUris,
+		FrontchannelLogoutUri:   requestBody.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    requestBody.BackchannelLogoutUri,
+		TokenExchangeAudiences:  requestBody.TokenExchangeAudiences,
+		Jwks:                    getRawJwks(requestBody.Jwks),
+		JwksUri:                 requestBody.JwksUri,
+	})
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponseWithData(context, NewBaseAppTemplate(app))
+}
+
+// getRawJwks jwks of the update request, nil when the field is absent and empty when it is null
+func getRawJwks(raw json.RawMessage) *string {
+	if raw == nil {
+		return nil
+	}
+	jwks := string(raw)
+	if jwks == "null" {
+		jwks = ""
+	}
+	return &jwks
+}
+
 var removeAppHandler haruka.RequestHandler = func(context *haruka.Context) {
 	user := context.Param["user"].(*database.User)
 	appId := context.GetPathParameterAsString("appid")
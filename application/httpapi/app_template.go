@@ -1,21 +1,60 @@
 package httpapi
 
-import "github.com/projectxpolaris/youauth/database"
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
 
 type BaseAppTemplate struct {
-	Id     uint   `json:"id"`
-	Name   string `json:"name"`
-	AppId  string `json:"appId,omitempty"`
-	Secret string `json:"secret,omitempty"`
+	Id                      uint            `json:"id"`
+	Name                    string          `json:"name"`
+	AppId                   string          `json:"appId,omitempty"`
+	Secret                  string          `json:"secret,omitempty"`
+	Callback                string          `json:"callback,omitempty"`
+	RedirectUris            []string        `json:"redirectUris,omitempty"`
+	AllowLoopbackPort       bool            `json:"allowLoopbackPort,omitempty"`
+	RequirePkce             bool            `json:"requirePkce,omitempty"`
+	RequirePar              bool            `json:"requirePar,omitempty"`
+	TokenEndpointAuthMethod string          `json:"tokenEndpointAuthMethod,omitempty"`
+	AllowedScopes           []string        `json:"allowedScopes"`
+	ClientCredentialsScopes []string        `json:"clientCredentialsScopes,omitempty"`
+	FirstParty              bool            `json:"firstParty"`
+	PostLogoutRedirectUris  []string        `json:"postLogoutRedirectUris"`
+	FrontchannelLogoutUri   string          `json:"frontchannelLogoutUri,omitempty"`
+	BackchannelLogoutUri    string          `json:"backchannelLogoutUri,omitempty"`
+	TokenExchangeAudiences  []string        `json:"tokenExchangeAudiences,omitempty"`
+	Jwks                    json.RawMessage `json:"jwks,omitempty"`
+	JwksUri                 string          `json:"jwksUri,omitempty"`
 }
 
 func NewBaseAppTemplate(app *database.App) BaseAppTemplate {
-	return BaseAppTemplate{
-		Id:     app.ID,
-		Name:   app.Name,
-		AppId:  app.AppId,
-		Secret: app.Secret,
+	template := BaseAppTemplate{
+		Id:                      app.ID,
+		Name:                    app.Name,
+		AppId:                   app.AppId,
+		Secret:                  app.Secret,
+		Callback:                app.Callback,
+		RedirectUris:            strings.Fields(app.RedirectUris),
+		AllowLoopbackPort:       app.AllowLoopbackPort,
+		RequirePkce:             app.RequirePkce,
+		RequirePar:              app.RequirePar,
+		TokenEndpointAuthMethod: app.TokenEndpointAuthMethod,
+		AllowedScopes:           service.GetAllowedScopes(app),
+		ClientCredentialsScopes: strings.Fields(app.ClientCredentialsScopes),
+		FirstParty:              app.FirstParty,
+		PostLogoutRedirectUris:  strings.Fields(app.PostLogoutRedirectUris),
+		FrontchannelLogoutUri:   app.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    app.BackchannelLogoutUri,
+		TokenExchangeAudiences:  strings.Fields(app.TokenExchangeAudiences),
+		JwksUri:                 app.JwksUri,
+	}
+	if app.Jwks != "" {
+		template.Jwks = json.RawMessage(app.Jwks)
 	}
+	return template
 }
 func NewBaseAppTemplateWithoutDetail(app *database.App) BaseAppTemplate {
 	return BaseAppTemplate{
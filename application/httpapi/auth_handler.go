@@ -3,23 +3,132 @@ package httpapi
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/allentom/haruka"
 	"github.com/projectxpolaris/youauth/config"
 	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/dpop"
 	"github.com/projectxpolaris/youauth/service"
 )
 
+// loginHandler authorization endpoint, served as /authorize and /login
 var loginHandler haruka.RequestHandler = func(context *haruka.Context) {
-	appId := context.GetQueryString("client_id")
+	params := context.Request.URL.Query()
+	appId := params.Get("client_id")
 	app, err := service.GetAppWithAppId(appId)
 	if err != nil {
-		RaiseErrorHtml(context)
+		RaiseErrorPage(context, service.InvalidateClient, http.StatusBadRequest)
+		return
+	}
+	// parameters pushed to /par or signed in a request object replace the query string, only client_id is taken from it
+	requestUri := params.Get("request_uri")
+	switch {
+	case requestUri != "":
+		params, err = service.GetPushedAuthorizationParameters(app, requestUri)
+	case app.RequirePar:
+		err = service.ParRequired
+	case params.Get("request") != "":
+		params, err = service.ParseRequestObject(app, params.Get("request"))
+	}
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	redirectUrl := params.Get("redirect_uri")
+	if redirectUrl == "" {
+		redirectUrl = params.Get("redirect_url")
+	}
+	// errors are only sent back to a redirect uri registered by the app
+	redirectUrl, err = service.ResolveRedirectUri(app, redirectUrl)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	state := params.Get("state")
+	responseType := params.Get("response_type")
+	if responseType == "" && context.Request.URL.Path == "/login" {
+		// clients of /login did not have to send response_type
+		responseType = "code"
+	}
+	if responseType == "" {
+		redirectWithError(context, redirectUrl, state, "invalid_request", "response_type is required")
+		return
+	}
+	if responseType != "code" {
+		redirectWithError(context, redirectUrl, state, "unsupported_response_type", "only response_type code is supported")
+		return
+	}
+	scope, err := service.ResolveScope(app, params.Get("scope"))
+	if err != nil {
+		redirectWithAuthorizeError(context, redirectUrl, state, err)
+		return
+	}
+	form := OauthLoginHandler{
+		Username:            params.Get("login_hint"),
+		AppId:               appId,
+		RedirectUrl:         redirectUrl,
+		Scope:               scope,
+		State:               state,
+		Nonce:               params.Get("nonce"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+		RequestUri:          requestUri,
+	}
+	if app.RequirePkce && form.CodeChallenge == "" {
+		redirectWithAuthorizeError(context, redirectUrl, state, service.CodeChallengeRequired)
+		return
+	}
+	prompt := strings.Fields(params.Get("prompt"))
+	promptNone := containsString(prompt, "none")
+	if promptNone && len(prompt) > 1 {
+		redirectWithError(context, redirectUrl, state, "invalid_request", "prompt none can not be combined with other values")
+		return
+	}
+	session := getCurrentSession(context)
+	if containsString(prompt, "login") {
+		session = nil
+	}
+	if rawMaxAge := params.Get("max_age"); rawMaxAge != "" {
+		maxAge, err := strconv.Atoi(rawMaxAge)
+		if err != nil || maxAge < 0 {
+			redirectWithError(context, redirectUrl, state, "invalid_request", "invalid max_age")
+			return
+		}
+		// the user has to enter the password again when the last authentication is too old
+		if session != nil && time.Since(session.AuthTime) > time.Duration(maxAge)*time.Second {
+			session = nil
+		}
+	}
+	if session != nil {
+		authCode, authRequest, err := service.AuthorizeApp(*session.UserId, app, form.getAuthCodeOption(session))
+		if err != nil {
+			redirectWithAuthorizeError(context, redirectUrl, state, err)
+			return
+		}
+		if !form.consumePushedRequest(context) {
+			return
+		}
+		if authRequest != nil {
+			if promptNone {
+				redirectWithError(context, redirectUrl, state, "consent_required", "the user has not granted the requested scope")
+				return
+			}
+			http.Redirect(context.Writer, context.Request, getConsentUrl(authRequest), http.StatusFound)
+			return
+		}
+		redirectToClient(context, redirectUrl, state, url.Values{"code": {authCode}})
+		return
+	}
+	if promptNone {
+		redirectWithError(context, redirectUrl, state, "login_required", "the user is not logged in")
 		return
 	}
-	redirectUrl := context.GetQueryString("redirect_url")
 	if config.Instance.ExternalLoginPage != "" {
 		url, err := url.Parse(config.Instance.ExternalLoginPage)
 		if err != nil {
@@ -29,6 +138,15 @@ var loginHandler haruka.RequestHandler = func(context *haruka.Context) {
 		query := url.Query()
 		query.Add("client_id", appId)
 		query.Add("redirect_url", redirectUrl)
+		query.Add("scope", scope)
+		query.Add("state", form.State)
+		query.Add("nonce", form.Nonce)
+		query.Add("login_hint", form.Username)
+		query.Add("code_challenge", form.CodeChallenge)
+		query.Add("code_challenge_method", form.CodeChallengeMethod)
+		if form.RequestUri != "" {
+			query.Add("request_uri", form.RequestUri)
+		}
 		url.RawQuery = query.Encode()
 
 		http.Redirect(
@@ -39,12 +157,26 @@ var loginHandler haruka.RequestHandler = func(context *haruka.Context) {
 		)
 		return
 	}
+	renderLoginPage(context, app, form, "")
+}
+
+// renderLoginPage password form, the authorization request is carried in hidden fields
+func renderLoginPage(context *haruka.Context, app *database.App, form OauthLoginHandler, errMessage string) {
 	context.HTML("./templates/login.html", map[string]interface{}{
-		"AppName":  app.Name,
-		"Redirect": redirectUrl,
-		"AppId":    appId,
+		"AppName":             app.Name,
+		"Redirect":            form.RedirectUrl,
+		"AppId":               form.AppId,
+		"Username":            form.Username,
+		"Scope":               form.Scope,
+		"State":               form.State,
+		"Nonce":               form.Nonce,
+		"CodeChallenge":       form.CodeChallenge,
+		"CodeChallengeMethod": form.CodeChallengeMethod,
+		"RequestUri":          form.RequestUri,
+		"Error":               errMessage,
 	})
 }
+
 var registerHandler haruka.RequestHandler = func(context *haruka.Context) {
 	context.HTML("./templates/register.html", map[string]interface{}{})
 }
@@ -52,6 +184,7 @@ var registerHandler haruka.RequestHandler = func(context *haruka.Context) {
 type RegisterUserForm struct {
 	Username string `hsource:"form" hname:"username"`
 	Password string `hsource:"form" hname:"password"`
+	Email    string `hsource:"form" hname:"email"`
 }
 
 var registerResultHandler haruka.RequestHandler = func(context *haruka.Context) {
@@ -66,7 +199,7 @@ var registerResultHandler haruka.RequestHandler = func(context *haruka.Context)
 		RaiseErrorHtml(context)
 		return
 	}
-	_, err = service.CreateUser(requestBody.Username, requestBody.Password)
+	_, err = service.CreateUser(requestBody.Username, requestBody.Password, requestBody.Email)
 	if err != nil {
 		RaiseErrorHtml(context)
 		return
@@ -81,10 +214,63 @@ var loginSuccessHandler haruka.RequestHandler = func(context *haruka.Context) {
 }
 
 type OauthLoginHandler struct {
-	Username    string `hsource:"form" hname:"username"`
-	Password    string `hsource:"form" hname:"password"`
-	AppId       string `hsource:"form" hname:"appid"`
-	RedirectUrl string `hsource:"form" hname:"redirect"`
+	Username            string `hsource:"form" hname:"username"`
+	Password            string `hsource:"form" hname:"password"`
+	AppId               string `hsource:"form" hname:"appid"`
+	RedirectUrl         string `hsource:"form" hname:"redirect"`
+	Scope               string `hsource:"form" hname:"scope"`
+	State               string `hsource:"form" hname:"state"`
+	Nonce               string `hsource:"form" hname:"nonce"`
+	CodeChallenge       string `hsource:"form" hname:"code_challenge"`
+	CodeChallengeMethod string `hsource:"form" hname:"code_challenge_method"`
+	RequestUri          string `hsource:"form" hname:"request_uri"`
+}
+
+// usePushedRequest take the authorization parameters from the pushed request instead of the submitted form
+func (f *OauthLoginHandler) usePushedRequest(app *database.App) error {
+	if f.RequestUri == "" {
+		if app.RequirePar {
+			return service.ParRequired
+		}
+		return nil
+	}
+	params, err := service.GetPushedAuthorizationParameters(app, f.RequestUri)
+	if err != nil {
+		return err
+	}
+	f.RedirectUrl = params.Get("redirect_uri")
+	f.Scope = params.Get("scope")
+	f.State = params.Get("state")
+	f.Nonce = params.Get("nonce")
+	f.CodeChallenge = params.Get("code_challenge")
+	f.CodeChallengeMethod = params.Get("code_challenge_method")
+	return nil
+}
+
+// consumePushedRequest the request uri can not be used again once the user has been authorized
+func (f OauthLoginHandler) consumePushedRequest(context *haruka.Context) bool {
+	if f.RequestUri == "" {
+		return true
+	}
+	err := service.RemovePushedAuthorizationRequest(f.RequestUri)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func (f OauthLoginHandler) getAuthCodeOption(session *database.Session) service.AuthCodeOption {
+	return service.AuthCodeOption{
+		RedirectUri:         f.RedirectUrl,
+		Scope:               f.Scope,
+		State:               f.State,
+		Nonce:               f.Nonce,
+		CodeChallenge:       f.CodeChallenge,
+		CodeChallengeMethod: f.CodeChallengeMethod,
+		AuthTime:            &session.AuthTime,
+		Sid:                 session.Sid,
+	}
 }
 
 var oauthLoginHandler haruka.RequestHandler = func(context *haruka.Context) {
@@ -99,35 +285,123 @@ var oauthLoginHandler haruka.RequestHandler = func(context *haruka.Context) {
 		RaiseErrorHtml(context)
 		return
 	}
-	_, authCode, err := service.LoginWithApp(requestBody.AppId, requestBody.Username, requestBody.Password)
+	app, err := service.GetAppWithAppId(requestBody.AppId)
+	if err != nil {
+		RaiseErrorPage(context, service.InvalidateClient, http.StatusBadRequest)
+		return
+	}
+	err = requestBody.usePushedRequest(app)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	requestBody.RedirectUrl, err = service.ResolveRedirectUri(app, requestBody.RedirectUrl)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	user, err := service.CheckUserPassword(requestBody.Username, requestBody.Password)
+	if err != nil {
+		renderLoginPage(context, app, requestBody, err.Error())
+		return
+	}
+	session, err := startSession(context, user.ID)
 	if err != nil {
 		RaiseErrorHtml(context)
 		return
 	}
-	u, err := url.Parse(requestBody.RedirectUrl)
+	authCode, authRequest, err := service.AuthorizeApp(user.ID, app, requestBody.getAuthCodeOption(session))
+	if err != nil {
+		redirectWithAuthorizeError(context, requestBody.RedirectUrl, requestBody.State, err)
+		return
+	}
+	if !requestBody.consumePushedRequest(context) {
+		return
+	}
+	if authRequest != nil {
+		http.Redirect(context.Writer, context.Request, getConsentUrl(authRequest), http.StatusFound)
+		return
+	}
+	redirectWithAuthCode(context, requestBody.RedirectUrl, requestBody.State, authCode)
+}
+
+// redirectToClient send the user straight back to the app with the response parameters and the state of the request
+func redirectToClient(context *haruka.Context, redirectUri string, state string, params url.Values) {
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		RaiseErrorHtml(context)
+		return
+	}
+	qry := u.Query()
+	for key, values := range params {
+		qry[key] = values
+	}
+	if state != "" {
+		qry.Set("state", state)
+	}
+	u.RawQuery = qry.Encode()
+	http.Redirect(context.Writer, context.Request, u.String(), http.StatusFound)
+}
+
+// redirectWithError report the error of the authorization request to the app (RFC 6749 section 4.1.2.1)
+func redirectWithError(context *haruka.Context, redirectUri string, state string, code string, description string) {
+	redirectToClient(context, redirectUri, state, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+// redirectWithAuthorizeError report the error of issuing the auth code
+func redirectWithAuthorizeError(context *haruka.Context, redirectUri string, state string, err error) {
+	code := "server_error"
+	switch err {
+	case service.InvalidateScope:
+		code = "invalid_scope"
+	case service.CodeChallengeRequired, service.InvalidateCodeChallengeMethod:
+		code = "invalid_request"
+	case service.UnauthorizedClient:
+		code = "unauthorized_client"
+	}
+	redirectWithError(context, redirectUri, state, code, err.Error())
+}
+
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectWithAuthCode send the user back to the app with the auth code through the success page
+func redirectWithAuthCode(context *haruka.Context, redirectUri string, state string, authCode string) {
+	u, err := url.Parse(redirectUri)
 	if err != nil {
 		RaiseErrorHtml(context)
 		return
 	}
 	qry := u.Query()
 	qry.Set("code", authCode)
+	if state != "" {
+		qry.Set("state", state)
+	}
 	u.RawQuery = qry.Encode()
-	red := u.String()
-	fmt.Println(red)
 	resultUrl, _ := url.Parse("/login/success")
 	resultQry := resultUrl.Query()
-	resultQry.Set("redirect", red)
+	resultQry.Set("redirect", u.String())
 	resultUrl.RawQuery = resultQry.Encode()
 	http.Redirect(context.Writer, context.Request, resultUrl.String(), http.StatusFound)
 }
 
 type GetOauthTokenData struct {
-	AppId     string `json:"appId"`
-	Code      string `json:"code"`
-	Secret    string `json:"secret"`
-	GrantType string `json:"grantType"`
-	Username  string `json:"username"`
-	Password  string `json:"password"`
+	AppId        string `json:"appId"`
+	Code         string `json:"code"`
+	Secret       string `json:"secret"`
+	GrantType    string `json:"grantType"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	CodeVerifier string `json:"codeVerifier"`
 }
 
 var getOauthTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
@@ -137,19 +411,35 @@ var getOauthTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
 		AbortError(context, err, http.StatusBadRequest)
 		return
 	}
-	var accessToken string
-	var refreshToken string
+	// the legacy endpoint authenticates the client in the same way as the token endpoint
+	app, ok := authenticateClient(context, requestBody.AppId, requestBody.Secret)
+	if !ok {
+		return
+	}
+	grantType := requestBody.GrantType
+	if grantType != "password" {
+		grantType = "authorization_code"
+	}
+	if err = service.CheckGrantType(app, grantType); err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	var appToken *service.AppToken
 	switch requestBody.GrantType {
 	case "password":
-		accessToken, refreshToken, err = service.GenerateAppTokenByPassword(requestBody.AppId, requestBody.Username, requestBody.Password)
+		appToken, err = service.GenerateAppTokenByPassword(app.AppId, requestBody.Username, requestBody.Password, "", "")
 	default:
-		accessToken, refreshToken, err = service.GenerateAppToken(requestBody.Code)
-		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
-		}
+		appToken, err = service.GenerateAppToken(service.AuthCodeGrantOption{
+			Code:         requestBody.Code,
+			ClientId:     app.AppId,
+			CodeVerifier: requestBody.CodeVerifier,
+		})
 	}
-	template := NewBaseAppAuthTemplate(accessToken, refreshToken)
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	template := NewBaseAppAuthTemplate(appToken)
 	context.JSON(template)
 }
 
@@ -160,61 +450,165 @@ type GenerateTokenBody struct {
 	Username     string `hsource:"form" hname:"username" json:"username"`
 	Password     string `hsource:"form" hname:"password" json:"password"`
 	RefreshToken string `hsource:"form" hname:"refresh_token" json:"refresh_token"`
+	CodeVerifier string `hsource:"form" hname:"code_verifier" json:"code_verifier"`
+	RedirectUri  string `hsource:"form" hname:"redirect_uri" json:"redirect_uri"`
+	ClientSecret string `hsource:"form" hname:"client_secret" json:"client_secret"`
+	Scope        string `hsource:"form" hname:"scope" json:"scope"`
+	DeviceCode   string `hsource:"form" hname:"device_code" json:"device_code"`
+	// token exchange (RFC 8693)
+	SubjectToken       string `hsource:"form" hname:"subject_token" json:"subject_token"`
+	SubjectTokenType   string `hsource:"form" hname:"subject_token_type" json:"subject_token_type"`
+	ActorToken         string `hsource:"form" hname:"actor_token" json:"actor_token"`
+	ActorTokenType     string `hsource:"form" hname:"actor_token_type" json:"actor_token_type"`
+	RequestedTokenType string `hsource:"form" hname:"requested_token_type" json:"requested_token_type"`
+	Audience           string `hsource:"form" hname:"audience" json:"audience"`
+	Resource           string `hsource:"form" hname:"resource" json:"resource"`
 }
 
-var generateTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
-	var requestBody GenerateTokenBody
-	var err error
-	// is form content type
-	if context.Request.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
-		err := context.Request.ParseForm()
+// getClientCredential client credential from the Authorization header (client_secret_basic) or from the request body
+func getClientCredential(context *haruka.Context, clientId string, clientSecret string) (service.ClientCredential, error) {
+	if assertion := context.Request.PostFormValue("client_assertion"); assertion != "" {
+		// only one authentication method is allowed in a request
+		if _, _, ok := context.Request.BasicAuth(); ok || clientSecret != "" {
+			return service.ClientCredential{}, service.InvalidateClient
+		}
+		if context.Request.PostFormValue("client_assertion_type") != service.ClientAssertionType {
+			return service.ClientCredential{}, service.InvalidateClient
+		}
+		return service.ClientCredential{ClientId: clientId, ClientAssertion: assertion, Method: service.ClientAuthMethodPrivateKeyJwt}, nil
+	}
+	if username, password, ok := context.Request.BasicAuth(); ok {
+		// only one authentication method is allowed in a request
+		if clientSecret != "" {
+			return service.ClientCredential{}, service.InvalidateClient
+		}
+		id, err := url.QueryUnescape(username)
 		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
+			return service.ClientCredential{}, service.InvalidateClient
 		}
-		err = context.BindingInput(&requestBody)
+		secret, err := url.QueryUnescape(password)
 		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
+			return service.ClientCredential{}, service.InvalidateClient
+		}
+		if clientId != "" && clientId != id {
+			return service.ClientCredential{}, service.InvalidateClient
 		}
+		return service.ClientCredential{ClientId: id, ClientSecret: secret, Method: service.ClientAuthMethodBasic}, nil
 	}
-	// is json content type
-	if context.Request.Header.Get("Content-Type") == "application/json" {
-		err := context.ParseJson(&requestBody)
-		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
+	if clientSecret != "" {
+		return service.ClientCredential{ClientId: clientId, ClientSecret: clientSecret, Method: service.ClientAuthMethodPost}, nil
+	}
+	return service.ClientCredential{ClientId: clientId, Method: service.ClientAuthMethodNone}, nil
+}
+
+// authenticateClient authenticate the client of the request, respond invalid_client on failure
+func authenticateClient(context *haruka.Context, clientId string, clientSecret string) (*database.App, bool) {
+	credential, err := getClientCredential(context, clientId, clientSecret)
+	if err == nil {
+		var app *database.App
+		app, err = service.AuthenticateClient(credential)
+		if err == nil {
+			return app, true
 		}
 	}
+	AbortOAuthError(context, "invalid_client", "client authentication failed", http.StatusUnauthorized)
+	return nil, false
+}
 
-	var accessToken string
-	var refreshToken string
+var generateTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
+	var requestBody GenerateTokenBody
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(context.Request.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		err = context.Request.ParseForm()
+		if err == nil {
+			err = context.BindingInput(&requestBody)
+		}
+	case "application/json":
+		err = context.ParseJson(&requestBody)
+	default:
+		err = errors.New("unsupported content type")
+	}
+	if err != nil {
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if requestBody.GrantType == "" {
+		AbortOAuthError(context, "invalid_request", "grant_type is required", http.StatusBadRequest)
+		return
+	}
+	app, ok := authenticateClient(context, requestBody.ClientId, requestBody.ClientSecret)
+	if !ok {
+		return
+	}
+	if err = service.CheckGrantType(app, requestBody.GrantType); err != nil {
+		abortTokenError(context, err)
+		return
+	}
+	// tokens are bound to the key of the DPoP proof when the request has one (RFC 9449)
+	jkt, ok := verifyTokenRequestProof(context)
+	if !ok {
+		return
+	}
+	var appToken *service.AppToken
 	switch requestBody.GrantType {
 	case "password":
-		accessToken, refreshToken, err = service.GenerateAppTokenByPassword(requestBody.ClientId, requestBody.Username, requestBody.Password)
-		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
-		}
+		appToken, err = service.GenerateAppTokenByPassword(app.AppId, requestBody.Username, requestBody.Password, requestBody.Scope, jkt)
 	case "authorization_code":
-		accessToken, refreshToken, err = service.GenerateAppToken(requestBody.Code)
-		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
-			return
-		}
+		appToken, err = service.GenerateAppToken(service.AuthCodeGrantOption{
+			Code:         requestBody.Code,
+			ClientId:     app.AppId,
+			CodeVerifier: requestBody.CodeVerifier,
+			RedirectUri:  requestBody.RedirectUri,
+			Jkt:          jkt,
+		})
 	case "refresh_token":
-		accessToken, refreshToken, err = service.RefreshToken(requestBody.RefreshToken)
-		if err != nil {
-			AbortError(context, err, http.StatusBadRequest)
+		appToken, err = service.RefreshToken(requestBody.RefreshToken, app, requestBody.Scope, jkt)
+	case deviceCodeGrantType:
+		appToken, err = service.GenerateDeviceToken(app, requestBody.DeviceCode, jkt)
+	case "client_credentials":
+		appToken, err = service.GenerateClientToken(app, requestBody.Scope, jkt)
+	case service.TokenExchangeGrantType:
+		if requestBody.SubjectToken == "" || requestBody.SubjectTokenType == "" {
+			AbortOAuthError(context, "invalid_request", "subject_token and subject_token_type are required", http.StatusBadRequest)
 			return
 		}
-
+		audience := requestBody.Audience
+		if audience == "" {
+			audience = requestBody.Resource
+		}
+		appToken, err = service.ExchangeToken(app, service.TokenExchangeOption{
+			SubjectToken:       requestBody.SubjectToken,
+			SubjectTokenType:   requestBody.SubjectTokenType,
+			ActorToken:         requestBody.ActorToken,
+			ActorTokenType:     requestBody.ActorTokenType,
+			RequestedTokenType: requestBody.RequestedTokenType,
+			Audience:           audience,
+			Scope:              requestBody.Scope,
+			Jkt:                jkt,
+		})
+	default:
+		AbortOAuthError(context, "unsupported_grant_type", fmt.Sprintf("grant type %s is not supported", requestBody.GrantType), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		abortTokenError(context, err)
+		return
+	}
+	// responses carrying tokens must not be cached (RFC 6749 section 5.1)
+	context.Writer.Header().Set("Cache-Control", "no-store")
+	context.Writer.Header().Set("Pragma", "no-cache")
+	template := NewBaseAppAuthTemplate(appToken)
+	if jkt != "" {
+		template.TokenType = dpop.TokenType
 	}
-	template := NewBaseAppAuthTemplate(accessToken, refreshToken)
 	context.JSON(template)
 }
 
 type RefreshOauthTokenData struct {
+	AppId        string `json:"appId"`
+	Secret       string `json:"secret"`
 	RefreshToken string `json:"refreshToken"`
 }
 
@@ -225,18 +619,35 @@ var refreshAccessToken haruka.RequestHandler = func(context *haruka.Context) {
 		AbortError(context, err, http.StatusBadRequest)
 		return
 	}
-	accessTokenString, refreshTokenString, err := service.RefreshToken(requestBody.RefreshToken)
+	app, ok := authenticateClient(context, requestBody.AppId, requestBody.Secret)
+	if !ok {
+		return
+	}
+	// the legacy endpoint refreshes in the same way as the refresh_token grant of the token endpoint
+	if err = service.CheckGrantType(app, "refresh_token"); err != nil {
+		abortTokenError(context, err)
+		return
+	}
+	jkt, ok := verifyTokenRequestProof(context)
+	if !ok {
+		return
+	}
+	appToken, err := service.RefreshToken(requestBody.RefreshToken, app, "", jkt)
 	if err != nil {
-		AbortError(context, err, http.StatusInternalServerError)
+		abortTokenError(context, err)
 		return
 	}
-	template := NewBaseAppAuthTemplate(accessTokenString, refreshTokenString)
+	template := NewBaseAppAuthTemplate(appToken)
+	if jkt != "" {
+		template.TokenType = dpop.TokenType
+	}
 	MakeSuccessResponseWithData(context, template)
 }
 
 type RegisterUserData struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"`
 }
 
 var createUserHandler haruka.RequestHandler = func(context *haruka.Context) {
@@ -246,7 +657,7 @@ var createUserHandler haruka.RequestHandler = func(context *haruka.Context) {
 		AbortError(context, err, http.StatusBadRequest)
 		return
 	}
-	user, err := service.CreateUser(requestBody.Username, requestBody.Password)
+	user, err := service.CreateUser(requestBody.Username, requestBody.Password, requestBody.Email)
 	template := NewUserTemplate(user)
 	MakeSuccessResponseWithData(context, template)
 }
@@ -349,11 +760,24 @@ var generateAuthCodeHandler = func(context *haruka.Context) {
 	}
 	user := rawUser.(*database.User)
 	appId := context.GetQueryString("appid")
-	authCode, err := service.LoginWithUser(user.ID, appId)
+	authCode, authRequest, err := service.LoginWithUser(user.ID, appId, service.AuthCodeOption{
+		Scope:               context.GetQueryString("scope"),
+		Nonce:               context.GetQueryString("nonce"),
+		CodeChallenge:       context.GetQueryString("code_challenge"),
+		CodeChallengeMethod: context.GetQueryString("code_challenge_method"),
+	})
 	if err != nil {
 		AbortError(context, err, http.StatusInternalServerError)
 		return
 	}
+	if authRequest != nil {
+		// the login page has to send the user to the consent page
+		MakeSuccessResponseWithData(context, haruka.JSON{
+			"consentRequired": true,
+			"consentUrl":      getConsentUrl(authRequest),
+		})
+		return
+	}
 	MakeSuccessResponseWithData(context, haruka.JSON{
 		"authCode": authCode,
 	})
@@ -3,7 +3,7 @@ package httpapi
 import (
 	"github.com/projectxpolaris/youauth/config"
 	"github.com/projectxpolaris/youauth/database"
-	"time"
+	"github.com/projectxpolaris/youauth/service"
 )
 
 const timeFormat = "2006-01-02 15:04:05"
@@ -11,12 +11,14 @@ const timeFormat = "2006-01-02 15:04:05"
 type BaseUserTemplate struct {
 	Id       uint   `json:"id"`
 	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
 }
 
 func NewUserTemplate(user *database.User) BaseUserTemplate {
 	return BaseUserTemplate{
 		Id:       user.Model.ID,
 		Username: user.Username,
+		Email:    user.Email,
 	}
 }
 func NewUserTemplateList(users []*database.User) []BaseUserTemplate {
@@ -43,17 +45,28 @@ func NewBaseUserAuthTemplate(token string, user *database.User) BaseUserAuthTemp
 
 type BaseAppAuthTemplate struct {
 	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IdToken      string `json:"id_token,omitempty"`
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType only set in token exchange responses (RFC 8693 section 2.2.1)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
-func NewBaseAppAuthTemplate(accessToken string, refreshToken string) BaseAppAuthTemplate {
+func NewBaseAppAuthTemplate(appToken *service.AppToken) BaseAppAuthTemplate {
+	expiresIn := config.Instance.JWTConfig.AccessTokenExpire
+	if appToken.ExpiresIn > 0 {
+		expiresIn = appToken.ExpiresIn
+	}
 	return BaseAppAuthTemplate{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    time.Now().Add(time.Duration(config.Instance.JWTConfig.AccessTokenExpire) * time.Second).Unix(),
-		TokenType:    "Bearer",
+		AccessToken:     appToken.AccessToken,
+		RefreshToken:    appToken.RefreshToken,
+		IdToken:         appToken.IdToken,
+		Scope:           appToken.Scope,
+		ExpiresIn:       expiresIn,
+		TokenType:       "Bearer",
+		IssuedTokenType: appToken.IssuedTokenType,
 	}
 }
 
@@ -62,3 +75,57 @@ type BaseTokenTemplate struct {
 	CreateAt string           `json:"createAt"`
 	App      *BaseAppTemplate `json:"app,omitempty"`
 }
+
+type UserInfoTemplate struct {
+	Sub               string `json:"sub"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+func NewUserInfoTemplate(user *database.User, scope string) UserInfoTemplate {
+	template := UserInfoTemplate{
+		Sub: service.GetUserSubject(user),
+	}
+	if service.HasScope(scope, service.ScopeProfile) {
+		template.Name = user.Username
+		template.PreferredUsername = user.Username
+	}
+	if service.HasScope(scope, service.ScopeEmail) && user.Email != "" {
+		verified := false
+		template.Email = user.Email
+		template.EmailVerified = &verified
+	}
+	return template
+}
+
+type IntrospectionTemplate struct {
+	Active    bool                       `json:"active"`
+	Subject   string                     `json:"sub,omitempty"`
+	ClientId  string                     `json:"client_id,omitempty"`
+	Scope     string                     `json:"scope,omitempty"`
+	ExpiresAt int64                      `json:"exp,omitempty"`
+	IssuedAt  int64                      `json:"iat,omitempty"`
+	TokenType string                     `json:"token_type,omitempty"`
+	Username  string                     `json:"username,omitempty"`
+	Audience  string                     `json:"aud,omitempty"`
+	Act       *service.ActorClaim        `json:"act,omitempty"`
+	Cnf       *service.ConfirmationClaim `json:"cnf,omitempty"`
+}
+
+func NewIntrospectionTemplate(introspection *service.TokenIntrospection) IntrospectionTemplate {
+	return IntrospectionTemplate{
+		Active:    introspection.Active,
+		Subject:   introspection.Subject,
+		ClientId:  introspection.ClientId,
+		Scope:     introspection.Scope,
+		ExpiresAt: introspection.ExpiresAt,
+		IssuedAt:  introspection.IssuedAt,
+		TokenType: introspection.TokenType,
+		Username:  introspection.Username,
+		Audience:  introspection.Audience,
+		Act:       introspection.Act,
+		Cnf:       introspection.Cnf,
+	}
+}
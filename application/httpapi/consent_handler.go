@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+func getConsentUrl(authRequest *database.AuthorizationRequest) string {
+	qry := url.Values{"request_id": {authRequest.RequestId}}
+	if authRequest.Ticket != "" {
+		qry.Set("ticket", authRequest.Ticket)
+	}
+	return "/consent?" + qry.Encode()
+}
+
+// getAppOwnerName username of the app owner shown to the user, empty if unknown
+func getAppOwnerName(app *database.App) string {
+	if app.UserId == nil {
+		return ""
+	}
+	user, err := service.GetUserById(strconv.Itoa(int(*app.UserId)))
+	if err != nil {
+		return ""
+	}
+	return user.Username
+}
+
+var consentHandler haruka.RequestHandler = func(context *haruka.Context) {
+	authRequest, err := service.GetSessionAuthorizationRequest(context.GetQueryString("request_id"), getCurrentSession(context), context.GetQueryString("ticket"))
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	scopes, err := service.GetScopesByNames(authRequest.Scope)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusInternalServerError)
+		return
+	}
+	context.HTML("./templates/consent.html", map[string]interface{}{
+		"AppName":   authRequest.App.Name,
+		"Owner":     getAppOwnerName(authRequest.App),
+		"Scopes":    scopes,
+		"RequestId": authRequest.RequestId,
+		"Ticket":    context.GetQueryString("ticket"),
+		"CsrfToken": authRequest.CsrfToken,
+	})
+}
+
+type ConsentForm struct {
+	RequestId string `hsource:"form" hname:"request_id"`
+	Ticket    string `hsource:"form" hname:"ticket"`
+	CsrfToken string `hsource:"form" hname:"csrf_token"`
+	Decision  string `hsource:"form" hname:"decision"`
+}
+
+var consentResultHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		RaiseErrorHtml(context)
+		return
+	}
+	var requestBody ConsentForm
+	err = context.BindingInput(&requestBody)
+	if err != nil {
+		RaiseErrorHtml(context)
+		return
+	}
+	session := getCurrentSession(context)
+	if requestBody.Decision != "approve" {
+		authRequest, err := service.DenyAuthorizationRequest(requestBody.RequestId, session, requestBody.Ticket, requestBody.CsrfToken)
+		if err != nil {
+			RaiseErrorPage(context, err, http.StatusBadRequest)
+			return
+		}
+		redirectUri, err := getAuthorizationRequestRedirectUri(authRequest)
+		if err != nil {
+			RaiseErrorPage(context, err, http.StatusBadRequest)
+			return
+		}
+		redirectWithError(context, redirectUri, authRequest.State, "access_denied", service.AccessDenied.Error())
+		return
+	}
+	authRequest, authCode, err := service.ApproveAuthorizationRequest(requestBody.RequestId, session, requestBody.Ticket, requestBody.CsrfToken)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	redirectUri, err := getAuthorizationRequestRedirectUri(authRequest)
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	redirectWithAuthCode(context, redirectUri, authRequest.State, authCode)
+}
+
+// getAuthorizationRequestRedirectUri requests of the external login page carry no redirect uri,
+// the user is sent back to the uri registered by the app
+func getAuthorizationRequestRedirectUri(authRequest *database.AuthorizationRequest) (string, error) {
+	if authRequest.RedirectUri != "" {
+		return authRequest.RedirectUri, nil
+	}
+	return service.ResolveRedirectUri(authRequest.App, "")
+}
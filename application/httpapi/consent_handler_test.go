@@ -0,0 +1,112 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+var csrfTokenPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// requestConsentUrl log in on the external login page and ask for an auth code, the app needs consent
+func requestConsentUrl(t *testing.T, accessToken string, appId string) string {
+	t.Helper()
+	context, recorder := newTestContext(http.MethodPost, "/oauth/authcode?"+url.Values{"appid": {appId}, "scope": {"openid profile"}}.Encode(), nil, map[string]string{
+		"Authorization": "Bearer " + accessToken,
+	})
+	(&AuthMiddleware{}).OnRequest(context)
+	if context.Param["user"] == nil {
+		t.Fatalf("self token rejected: %s", recorder.Body.String())
+	}
+	generateAuthCodeHandler(context)
+	data, _ := decodeTestResponse(t, recorder)["data"].(map[string]interface{})
+	if data["consentRequired"] != true {
+		t.Fatalf("expected consent to be required, got %s", recorder.Body.String())
+	}
+	return data["consentUrl"].(string)
+}
+
+func TestExternalLoginConsent(t *testing.T) {
+	newTestUser(t, "consent-user", "password")
+	accessToken, _, err := service.GenerateSelfToken("consent-user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, &database.App{AppId: "consent-app", Name: "Consent App", Secret: "secret", RedirectUris: "https://app.example.com/callback"})
+
+	consentUrl := requestConsentUrl(t, accessToken, app.AppId)
+	parsed, err := url.Parse(consentUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket := parsed.Query().Get("ticket")
+	if ticket == "" {
+		t.Fatalf("consent url %s carries no ticket", consentUrl)
+	}
+
+	// the consent page is opened in a browser without a session of youauth
+	context, recorder := newTestContext(http.MethodGet, consentUrl, nil, nil)
+	consentHandler(context)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("consent page: status %d", recorder.Code)
+	}
+	match := csrfTokenPattern.FindStringSubmatch(recorder.Body.String())
+	if match == nil {
+		t.Fatal("consent page has no csrf token")
+	}
+	form := url.Values{
+		"request_id": {parsed.Query().Get("request_id")},
+		"ticket":     {ticket},
+		"csrf_token": {match[1]},
+		"decision":   {"approve"},
+	}
+
+	t.Run("wrong ticket is rejected", func(t *testing.T) {
+		wrongTicket := url.Values{}
+		for name, values := range form {
+			wrongTicket[name] = values
+		}
+		wrongTicket.Set("ticket", "wrong")
+		context, recorder := newTestContext(http.MethodPost, "/consent", wrongTicket, nil)
+		consentResultHandler(context)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+
+	t.Run("approve issues the auth code", func(t *testing.T) {
+		context, recorder := newTestContext(http.MethodPost, "/consent", form, nil)
+		consentResultHandler(context)
+		if recorder.Code != http.StatusFound {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusFound, recorder.Code, recorder.Body.String())
+		}
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		redirect, err := url.Parse(location.Query().Get("redirect"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redirect.Scheme+"://"+redirect.Host+redirect.Path != "https://app.example.com/callback" {
+			t.Fatalf("unexpected redirect %s", redirect)
+		}
+		authCode := &database.AuthorizationCode{}
+		err = database.Instance.Where("code = ?", redirect.Query().Get("code")).First(authCode).Error
+		if err != nil {
+			t.Fatalf("auth code not issued: %v", err)
+		}
+	})
+
+	t.Run("request is answered once", func(t *testing.T) {
+		context, recorder := newTestContext(http.MethodPost, "/consent", form, nil)
+		consentResultHandler(context)
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+var deviceCodeHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+	app, ok := authenticateClient(context, context.Request.PostFormValue("client_id"), context.Request.PostFormValue("client_secret"))
+	if !ok {
+		return
+	}
+	if err = service.CheckGrantType(app, deviceCodeGrantType); err != nil {
+		AbortOAuthError(context, "unauthorized_client", err.Error(), http.StatusBadRequest)
+		return
+	}
+	device, err := service.CreateDeviceAuthorization(app, context.Request.PostFormValue("scope"))
+	if err == service.InvalidateScope {
+		AbortOAuthError(context, "invalid_scope", err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context.JSON(NewDeviceAuthorizationTemplate(device))
+}
+
+// renderDevicePage show the user code form, or the app and scopes of the device authorization when the code is known
+func renderDevicePage(context *haruka.Context, userCode string, data map[string]interface{}) {
+	if userCode != "" {
+		device, err := service.GetPendingDeviceAuthorization(userCode)
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			scopes, err := service.GetScopesByNames(device.Scope)
+			if err != nil {
+				RaiseErrorPage(context, err, http.StatusInternalServerError)
+				return
+			}
+			data["AppName"] = device.App.Name
+			data["Owner"] = getAppOwnerName(device.App)
+			data["Scopes"] = scopes
+			data["UserCode"] = device.UserCode
+		}
+	}
+	context.HTML("./templates/device.html", data)
+}
+
+var devicePageHandler haruka.RequestHandler = func(context *haruka.Context) {
+	renderDevicePage(context, context.GetQueryString("user_code"), map[string]interface{}{})
+}
+
+type DeviceForm struct {
+	UserCode string `hsource:"form" hname:"user_code"`
+	Username string `hsource:"form" hname:"username"`
+	Password string `hsource:"form" hname:"password"`
+	Decision string `hsource:"form" hname:"decision"`
+}
+
+var deviceResultHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		RaiseErrorHtml(context)
+		return
+	}
+	var requestBody DeviceForm
+	err = context.BindingInput(&requestBody)
+	if err != nil {
+		RaiseErrorHtml(context)
+		return
+	}
+	user, err := service.CheckUserPassword(requestBody.Username, requestBody.Password)
+	if err != nil {
+		renderDevicePage(context, requestBody.UserCode, map[string]interface{}{
+			"Error": err.Error(),
+		})
+		return
+	}
+	approve := requestBody.Decision == "approve"
+	err = service.AnswerDeviceAuthorization(requestBody.UserCode, user.ID, approve)
+	if err != nil {
+		renderDevicePage(context, "", map[string]interface{}{
+			"Error": err.Error(),
+		})
+		return
+	}
+	message := "The device has been denied."
+	if approve {
+		message = "The device is connected, you can return to it now."
+	}
+	context.HTML("./templates/device.html", map[string]interface{}{
+		"Message": message,
+	})
+}
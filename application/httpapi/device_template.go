@@ -0,0 +1,30 @@
+package httpapi
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+)
+
+type DeviceAuthorizationTemplate struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+func NewDeviceAuthorizationTemplate(device *database.DeviceCode) DeviceAuthorizationTemplate {
+	verificationUri := config.Instance.JWTConfig.GetBaseUrl() + "/device"
+	return DeviceAuthorizationTemplate{
+		DeviceCode:              device.DeviceCode,
+		UserCode:                device.UserCode,
+		VerificationUri:         verificationUri,
+		VerificationUriComplete: verificationUri + "?" + url.Values{"user_code": {device.UserCode}}.Encode(),
+		ExpiresIn:               int64(time.Until(device.ExpiresAt).Seconds()),
+		Interval:                device.Interval,
+	}
+}
@@ -0,0 +1,89 @@
+package httpapi
+
+import (
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+type OpenIDConfigurationTemplate struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	UserinfoEndpoint                           string   `json:"userinfo_endpoint"`
+	JwksUri                                    string   `json:"jwks_uri"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint"`
+	EndSessionEndpoint                         string   `json:"end_session_endpoint"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint"`
+	PushedAuthorizationRequestEndpoint         string   `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorizationRequests         bool     `json:"require_pushed_authorization_requests"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported"`
+	IdTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported     []string `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported  []string `json:"introspection_endpoint_auth_methods_supported"`
+	ClaimsSupported                            []string `json:"claims_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	RequestParameterSupported                  bool     `json:"request_parameter_supported"`
+	RequestUriParameterSupported               bool     `json:"request_uri_parameter_supported"`
+	RequestObjectSigningAlgValuesSupported     []string `json:"request_object_signing_alg_values_supported"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported"`
+	FrontchannelLogoutSupported                bool     `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSessionSupported         bool     `json:"frontchannel_logout_session_supported"`
+	BackchannelLogoutSupported                 bool     `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported          bool     `json:"backchannel_logout_session_supported"`
+}
+
+func NewOpenIDConfigurationTemplate(scopes []*database.Scope) OpenIDConfigurationTemplate {
+	baseUrl := config.Instance.JWTConfig.GetBaseUrl()
+	authMethods := []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"}
+	// algorithms of client assertions, request objects and DPoP proofs signed with the keys of the client
+	clientSigningAlgs := []string{"RS256", "PS256", "ES256", "EdDSA"}
+	scopeNames := make([]string, 0)
+	for _, scope := range scopes {
+		scopeNames = append(scopeNames, scope.Name)
+	}
+	return OpenIDConfigurationTemplate{
+		Issuer:                                    config.Instance.JWTConfig.GetIssuer(),
+		AuthorizationEndpoint:                     baseUrl + "/authorize",
+		TokenEndpoint:                             baseUrl + "/token",
+		UserinfoEndpoint:                          baseUrl + "/userinfo",
+		JwksUri:                                   baseUrl + "/.well-known/jwks.json",
+		RevocationEndpoint:                        baseUrl + "/revoke",
+		IntrospectionEndpoint:                     baseUrl + "/introspect",
+		DeviceAuthorizationEndpoint:               baseUrl + "/device/code",
+		EndSessionEndpoint:                        baseUrl + "/logout",
+		RegistrationEndpoint:                      baseUrl + "/connect/register",
+		PushedAuthorizationRequestEndpoint:        baseUrl + "/par",
+		RequirePushedAuthorizationRequests:        false,
+		GrantTypesSupported:                       []string{"authorization_code", "password", "refresh_token", "client_credentials", deviceCodeGrantType, service.TokenExchangeGrantType},
+		ResponseTypesSupported:                    []string{"code"},
+		ScopesSupported:                           scopeNames,
+		SubjectTypesSupported:                     []string{"public"},
+		IdTokenSigningAlgValuesSupported:          []string{config.Instance.JWTConfig.SigningAlgorithm},
+		TokenEndpointAuthMethodsSupported:         authMethods,
+		RevocationEndpointAuthMethodsSupported:    authMethods,
+		IntrospectionEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "preferred_username", "email", "email_verified", "sid",
+		},
+		CodeChallengeMethodsSupported:              []string{"S256", "plain"},
+		TokenEndpointAuthSigningAlgValuesSupported: clientSigningAlgs,
+		RequestParameterSupported:                  true,
+		// request_uri only accepts uris returned by the pushed authorization request endpoint
+		RequestUriParameterSupported:           false,
+		RequestObjectSigningAlgValuesSupported: clientSigningAlgs,
+		DPoPSigningAlgValuesSupported:          clientSigningAlgs,
+		FrontchannelLogoutSupported:            true,
+		FrontchannelLogoutSessionSupported:     true,
+		BackchannelLogoutSupported:             true,
+		BackchannelLogoutSessionSupported:      true,
+	}
+}
@@ -0,0 +1,75 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/dpop"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// getRequestUrl public url of the request, DPoP proofs are made for the url the client sees
+func getRequestUrl(context *haruka.Context) string {
+	baseUrl := config.Instance.JWTConfig.GetBaseUrl()
+	if baseUrl == "" {
+		scheme := "http"
+		if context.Request.TLS != nil {
+			scheme = "https"
+		}
+		baseUrl = scheme + "://" + context.Request.Host
+	}
+	return baseUrl + context.Request.URL.Path
+}
+
+// isNonceError the client has to retry with the nonce of the DPoP-Nonce header
+func isNonceError(err error) bool {
+	return err == dpop.NonceRequired || err == dpop.InvalidateNonce
+}
+
+// verifyTokenRequestProof verify the DPoP proof sent to the token endpoint and return the thumbprint of its key,
+// empty without proof. Respond the error of the token endpoint on failure
+func verifyTokenRequestProof(context *haruka.Context) (string, bool) {
+	proof, err := dpop.GetProof(context.Request)
+	if err == nil && proof == "" {
+		return "", true
+	}
+	// a fresh nonce is handed out with every response to a DPoP request
+	context.Writer.Header().Set(dpop.NonceHeaderName, service.GetDPoPNonce())
+	var result *dpop.Proof
+	if err == nil {
+		result, err = service.VerifyDPoPProof(proof, context.Request.Method, getRequestUrl(context), "")
+	}
+	if isNonceError(err) {
+		AbortOAuthError(context, "use_dpop_nonce", err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	if err != nil {
+		AbortOAuthError(context, "invalid_dpop_proof", err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return result.Jkt, true
+}
+
+// verifyResourceRequestProof verify the DPoP proof sent with the access token to a protected resource.
+// Respond the DPoP challenge on failure
+func verifyResourceRequestProof(context *haruka.Context, accessToken string) (string, bool) {
+	proof, err := dpop.GetProof(context.Request)
+	if err == nil && proof == "" {
+		err = dpop.InvalidateProof
+	}
+	var result *dpop.Proof
+	if err == nil {
+		result, err = service.VerifyDPoPProof(proof, context.Request.Method, getRequestUrl(context), accessToken)
+	}
+	if isNonceError(err) {
+		context.Writer.Header().Set(dpop.NonceHeaderName, service.GetDPoPNonce())
+		AbortDPoPError(context, "use_dpop_nonce", err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	if err != nil {
+		AbortDPoPError(context, "invalid_dpop_proof", err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return result.Jkt, true
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/dpop"
+	"github.com/projectxpolaris/youauth/util"
+	"github.com/rs/xid"
+)
+
+type testDPoPKey struct {
+	privateKey *ecdsa.PrivateKey
+	jwk        map[string]interface{}
+}
+
+func newTestDPoPKey(t *testing.T) *testDPoPKey {
+	t.Helper()
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := util.NewJWK(&privateKey.PublicKey, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &testDPoPKey{privateKey: privateKey}
+	if err = json.Unmarshal(raw, &key.jwk); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// proof DPoP proof of the key for the request, ath is added when accessToken is set
+func (k *testDPoPKey) proof(t *testing.T, method string, path string, accessToken string) string {
+	t.Helper()
+	claims := jwt.MapClaims{
+		"jti": xid.New().String(),
+		"htm": method,
+		"htu": testBaseUrl + path,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.AccessTokenHash(accessToken)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk
+	proof, err := token.SignedString(k.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// requestToken call the token endpoint, the request carries a proof of key when key is not nil
+func requestToken(t *testing.T, form url.Values, key *testDPoPKey) (int, map[string]interface{}) {
+	t.Helper()
+	header := map[string]string{}
+	if key != nil {
+		header[dpop.HeaderName] = key.proof(t, http.MethodPost, "/token", "")
+	}
+	context, recorder := newTestContext(http.MethodPost, "/token", form, header)
+	generateTokenHandler(context)
+	return recorder.Code, decodeTestResponse(t, recorder)
+}
+
+// requestLegacyRefresh call the legacy refresh endpoint, the request carries a proof of key when key is not nil
+func requestLegacyRefresh(t *testing.T, appId string, refreshToken string, key *testDPoPKey) (int, map[string]interface{}) {
+	t.Helper()
+	body, err := json.Marshal(RefreshOauthTokenData{AppId: appId, RefreshToken: refreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, recorder := newTestContext(http.MethodPost, "/oauth/refresh", nil, nil)
+	context.Request = httptest.NewRequest(http.MethodPost, "/oauth/refresh", bytes.NewReader(body))
+	context.Request.Header.Set("Content-Type", "application/json")
+	if key != nil {
+		context.Request.Header.Set(dpop.HeaderName, key.proof(t, http.MethodPost, "/oauth/refresh", ""))
+	}
+	refreshAccessToken(context)
+	return recorder.Code, decodeTestResponse(t, recorder)
+}
+
+func TestDPoPBoundTokens(t *testing.T) {
+	newTestUser(t, "dpop-user", "password")
+	app := newTestApp(t, &database.App{AppId: "dpop-app", Name: "DPoP App", TokenEndpointAuthMethod: "none"})
+	key := newTestDPoPKey(t)
+	status, result := requestToken(t, url.Values{
+		"grant_type": {"password"},
+		"client_id":  {app.AppId},
+		"username":   {"dpop-user"},
+		"password":   {"password"},
+		"scope":      {"profile"},
+	}, key)
+	if status != http.StatusOK || result["token_type"] != dpop.TokenType {
+		t.Fatalf("password grant: status %d, %v", status, result)
+	}
+	accessToken := result["access_token"].(string)
+	refreshToken, _ := result["refresh_token"].(string)
+	if refreshToken == "" {
+		t.Fatal("public client with DPoP got no refresh token")
+	}
+
+	t.Run("userinfo", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header map[string]string
+			status int
+		}{
+			{name: "bound token as bearer", header: map[string]string{"Authorization": "Bearer " + accessToken}, status: http.StatusUnauthorized},
+			{name: "proof of another key", header: map[string]string{
+				"Authorization": dpop.TokenType + " " + accessToken,
+				dpop.HeaderName: newTestDPoPKey(t).proof(t, http.MethodGet, "/userinfo", accessToken),
+			}, status: http.StatusUnauthorized},
+			{name: "proof of the bound key", header: map[string]string{
+				"Authorization": dpop.TokenType + " " + accessToken,
+				dpop.HeaderName: key.proof(t, http.MethodGet, "/userinfo", accessToken),
+			}, status: http.StatusOK},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				context, recorder := newTestContext(http.MethodGet, "/userinfo", nil, test.header)
+				userInfoHandler(context)
+				if recorder.Code != test.status {
+					t.Fatalf("expected status %d, got %d: %s", test.status, recorder.Code, recorder.Body.String())
+				}
+			})
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		refresh := url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {app.AppId},
+			"refresh_token": {refreshToken},
+		}
+		tests := []struct {
+			name   string
+			legacy bool
+			key    *testDPoPKey
+			status int
+			error  string
+		}{
+			{name: "without proof", status: http.StatusBadRequest, error: "invalid_dpop_proof"},
+			{name: "proof of another key", key: newTestDPoPKey(t), status: http.StatusBadRequest, error: "invalid_dpop_proof"},
+			{name: "legacy endpoint without proof", legacy: true, status: http.StatusBadRequest, error: "invalid_dpop_proof"},
+			{name: "legacy endpoint with proof of another key", legacy: true, key: newTestDPoPKey(t), status: http.StatusBadRequest, error: "invalid_dpop_proof"},
+			{name: "proof of the bound key", key: key, status: http.StatusOK},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				var status int
+				var result map[string]interface{}
+				if test.legacy {
+					status, result = requestLegacyRefresh(t, app.AppId, refreshToken, test.key)
+				} else {
+					status, result = requestToken(t, refresh, test.key)
+				}
+				if status != test.status {
+					t.Fatalf("expected status %d, got %d: %v", test.status, status, result)
+				}
+				if test.error != "" && result["error"] != test.error {
+					t.Fatalf("expected error %s, got %v", test.error, result["error"])
+				}
+			})
+		}
+	})
+}
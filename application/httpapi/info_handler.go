@@ -3,6 +3,7 @@ package httpapi
 import (
 	"github.com/allentom/haruka"
 	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/service"
 	"net/http"
 	"net/url"
 )
@@ -27,3 +28,21 @@ var infoHandler haruka.RequestHandler = func(context *haruka.Context) {
 		},
 	})
 }
+
+var openIDConfigurationHandler haruka.RequestHandler = func(context *haruka.Context) {
+	scopes, err := service.GetScopeList()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	context.JSON(NewOpenIDConfigurationTemplate(scopes))
+}
+
+var jwksHandler haruka.RequestHandler = func(context *haruka.Context) {
+	jwks, err := service.GetJWKS()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	context.JSON(jwks)
+}
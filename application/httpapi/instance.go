@@ -73,16 +73,29 @@ func GetEngine() *haruka.Engine {
 	e.UseMiddleware(middleware.NewPaginationMiddleware("page", "pageSize", 1, 20))
 	e.UseMiddleware(&AuthMiddleware{})
 	e.Router.GET("/login", loginHandler)
+	e.Router.GET("/authorize", loginHandler)
 	e.Router.POST("/login/register", registerResultHandler)
 	e.Router.GET("/register", registerHandler)
 	e.Router.GET("/login/success", loginSuccessHandler)
 	e.Router.POST("/login/oauth", oauthLoginHandler)
+	e.Router.GET("/consent", consentHandler)
+	e.Router.POST("/consent", consentResultHandler)
+	e.Router.POST("/device/code", deviceCodeHandler)
+	e.Router.GET("/device", devicePageHandler)
+	e.Router.POST("/device", deviceResultHandler)
+	e.Router.METHODS("/logout", []string{http.MethodGet, http.MethodPost}, endSessionHandler)
 	e.Router.POST("/oauth/token", getOauthTokenHandler)
 	e.Router.POST("/token", generateTokenHandler)
 	e.Router.POST("/oauth/refresh", refreshAccessToken)
+	e.Router.POST("/introspect", introspectHandler)
+	e.Router.POST("/revoke", revokeHandler)
+	e.Router.POST("/par", pushAuthorizationRequestHandler)
+	e.Router.POST("/connect/register", registerClientHandler)
+	e.Router.METHODS("/connect/register/{clientid}", []string{http.MethodGet, http.MethodPut, http.MethodDelete}, clientConfigurationHandler)
 	e.Router.GET("/oauth/app", getAppHandler)
 	e.Router.POST("/oauth/authcode", generateAuthCodeHandler)
 	e.Router.GET("/auth/current", getCurrentUserHandler)
+	e.Router.METHODS("/userinfo", []string{http.MethodGet, http.MethodPost}, userInfoHandler)
 	e.Router.POST("/users/register", createUserHandler)
 	e.Router.GET("/users", getUserListHandler)
 	e.Router.DELETE("/user/appid:[0-9]+", deleteUserHandler)
@@ -90,8 +103,19 @@ func GetEngine() *haruka.Engine {
 	e.Router.POST("/apps", createAppHandler)
 	e.Router.GET("/apps", getAppListHandler)
 	e.Router.POST("/my/password", changePasswordHandler)
+	e.Router.PATCH("/app/{appid:[0-9|a-z|A-Z]+}", updateAppHandler)
 	e.Router.DELETE("/app/{appid:[0-9|a-z|A-Z]+}", removeAppHandler)
 	e.Router.GET("/info", infoHandler)
+	e.Router.GET("/.well-known/openid-configuration", openIDConfigurationHandler)
+	e.Router.GET("/.well-known/jwks.json", jwksHandler)
+	e.Router.GET("/admin/keys", getSigningKeyListHandler)
+	e.Router.POST("/admin/keys/rotate", rotateSigningKeyHandler)
+	e.Router.GET("/scopes", getScopeListHandler)
+	e.Router.POST("/admin/scopes", createScopeHandler)
+	e.Router.DELETE("/admin/scopes/{name}", removeScopeHandler)
+	e.Router.GET("/admin/registration-tokens", getInitialAccessTokenListHandler)
+	e.Router.POST("/admin/registration-tokens", createInitialAccessTokenHandler)
+	e.Router.DELETE("/admin/registration-tokens/{id:[0-9]+}", removeInitialAccessTokenHandler)
 	if util.CheckFileExist("./dist") && util.FolderIsNotEmpty("./dist") && util.CheckFileExist("./dist/index.html") {
 		e.Router.HandlerRouter.PathPrefix("/api").HandlerFunc(adminAPIReverse)
 		e.Router.HandlerRouter.PathPrefix("/").Handler(spaHandler{
@@ -0,0 +1,94 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// endSessionHandler RP-initiated logout, ends the SSO session and logs the user out of every app signed in through it.
+// A GET request may be forged by any site, unless the id_token_hint was issued in the current session
+// the user is asked to confirm with a POST instead
+var endSessionHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		RaiseErrorPage(context, err, http.StatusBadRequest)
+		return
+	}
+	clientId := context.Request.Form.Get("client_id")
+	var claims *service.IdTokenClaim
+	if idTokenHint := context.Request.Form.Get("id_token_hint"); idTokenHint != "" {
+		claims, err = service.ParseIdTokenHint(idTokenHint)
+		if err != nil {
+			RaiseErrorPage(context, err, http.StatusBadRequest)
+			return
+		}
+		if clientId != "" && clientId != claims.Audience {
+			RaiseErrorPage(context, service.InvalidateIdTokenHint, http.StatusBadRequest)
+			return
+		}
+		clientId = claims.Audience
+	}
+	redirectUri := ""
+	if postLogoutRedirectUri := context.Request.Form.Get("post_logout_redirect_uri"); postLogoutRedirectUri != "" {
+		// the app has to be known to validate the uri
+		app, err := service.GetAppByAppId(clientId)
+		if err != nil {
+			RaiseErrorPage(context, service.InvalidatePostLogoutRedirectUri, http.StatusBadRequest)
+			return
+		}
+		err = service.CheckPostLogoutRedirectUri(app, postLogoutRedirectUri)
+		if err != nil {
+			RaiseErrorPage(context, err, http.StatusBadRequest)
+			return
+		}
+		u, _ := url.Parse(postLogoutRedirectUri)
+		if state := context.Request.Form.Get("state"); state != "" {
+			qry := u.Query()
+			qry.Set("state", state)
+			u.RawQuery = qry.Encode()
+		}
+		redirectUri = u.String()
+	}
+	session := getCurrentSession(context)
+	if context.Request.Method == http.MethodGet && session != nil && (claims == nil || !service.IsIdTokenHintOfSession(claims, session)) {
+		context.HTML("./templates/logout_confirm.html", map[string]interface{}{
+			"ClientId":              clientId,
+			"PostLogoutRedirectUri": context.Request.Form.Get("post_logout_redirect_uri"),
+			"State":                 context.Request.Form.Get("state"),
+			"Redirect":              redirectUri,
+		})
+		return
+	}
+	frontchannelUrls := make([]string, 0)
+	if token := getSessionToken(context); token != "" {
+		clearSessionCookie(context)
+		session, apps, err := service.EndSession(token)
+		if err != nil {
+			RaiseErrorPage(context, err, http.StatusInternalServerError)
+			return
+		}
+		if session != nil {
+			for _, app := range apps {
+				if app.FrontchannelLogoutUri == "" {
+					continue
+				}
+				frontchannelUrl, err := service.GetFrontchannelLogoutUrl(app, session.Sid)
+				if err != nil {
+					continue
+				}
+				frontchannelUrls = append(frontchannelUrls, frontchannelUrl)
+			}
+		}
+	}
+	if len(frontchannelUrls) == 0 && redirectUri != "" {
+		http.Redirect(context.Writer, context.Request, redirectUri, http.StatusFound)
+		return
+	}
+	context.HTML("./templates/logout.html", map[string]interface{}{
+		"FrontchannelUrls": frontchannelUrls,
+		"Redirect":         redirectUri,
+	})
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testBaseUrl = "https://auth.example.com"
+
+func TestMain(m *testing.M) {
+	// templates are loaded relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		panic(err)
+	}
+	database.DefaultPlugin.OnConnected(db)
+	config.Instance.JWTConfig = config.JWTConfig{
+		Issuer:             testBaseUrl,
+		Url:                testBaseUrl,
+		AccessTokenExpire:  3600,
+		RefreshTokenExpire: 86400,
+		AuthCodeExpires:    600,
+		IdTokenExpire:      3600,
+		SigningAlgorithm:   "ES256",
+	}
+	config.Instance.SessionConfig = config.SessionConfig{IdleTimeout: 3600, AbsoluteTimeout: 86400}
+	os.Exit(m.Run())
+}
+
+func newTestUser(t *testing.T, username string, password string) *database.User {
+	t.Helper()
+	user, err := service.CreateUser(username, password, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newTestApp(t *testing.T, app *database.App) *database.App {
+	t.Helper()
+	if err := database.Instance.Create(app).Error; err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// newTestContext request context of a handler, form is sent url encoded when not nil
+func newTestContext(method string, target string, form url.Values, header map[string]string) (*haruka.Context, *httptest.ResponseRecorder) {
+	body := ""
+	if form != nil {
+		body = form.Encode()
+	}
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	return &haruka.Context{Writer: recorder, Request: request, Param: map[string]interface{}{}}, recorder
+}
+
+func decodeTestResponse(t *testing.T, recorder *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	result := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode response %q: %v", recorder.Body.String(), err)
+	}
+	return result
+}
@@ -14,6 +14,11 @@ var NoAuthUrls = []string{
 	"/register",
 	"/login/success",
 	"/login/oauth",
+	"/authorize",
+	"/consent",
+	"/device",
+	"/device/code",
+	"/logout",
 	"/oauth/token",
 	"/oauth/refresh",
 	"/auth/current",
@@ -22,6 +27,18 @@ var NoAuthUrls = []string{
 	"/users/register",
 	"/oauth/app",
 	"/token",
+	"/.well-known/openid-configuration",
+	"/.well-known/jwks.json",
+	"/userinfo",
+	"/introspect",
+	"/revoke",
+	"/par",
+	"/connect/register",
+}
+
+// NoAuthPrefixes paths under these prefixes authenticate the request by themselves
+var NoAuthPrefixes = []string{
+	"/connect/register/",
 }
 
 type AuthMiddleware struct {
@@ -33,6 +50,11 @@ func (m *AuthMiddleware) OnRequest(ctx *haruka.Context) {
 			return
 		}
 	}
+	for _, prefix := range NoAuthPrefixes {
+		if strings.HasPrefix(ctx.Request.URL.Path, prefix) {
+			return
+		}
+	}
 
 	rawString := ctx.Request.Header.Get("Authorization")
 	if len(rawString) == 0 {
@@ -50,7 +72,19 @@ func (m *AuthMiddleware) OnRequest(ctx *haruka.Context) {
 		AbortError(ctx, err, http.StatusForbidden)
 		return
 	}
-	user, err := service.GetUserByUsername(token.Id)
+	// account and admin apis only accept access tokens issued to youauth itself
+	if !service.IsSelfAccessToken(token) {
+		ctx.Abort()
+		AbortError(ctx, service.InvalidateAppError, http.StatusForbidden)
+		return
+	}
+	// tokens bound to a DPoP key can not be used as bearer tokens
+	if token.Cnf != nil {
+		ctx.Abort()
+		AbortError(ctx, service.DPoPProofRequired, http.StatusForbidden)
+		return
+	}
+	user, err := service.GetUserByUsername(token.GetUsername())
 	if err != nil {
 		ctx.Abort()
 		AbortError(ctx, err, http.StatusForbidden)
@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/dpop"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// getBearerToken access token from the Authorization header or the access_token form parameter (RFC 6750)
+func getBearerToken(context *haruka.Context) string {
+	authorization := context.Request.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	if context.Request.Method == http.MethodPost {
+		return context.Request.PostFormValue("access_token")
+	}
+	return ""
+}
+
+var userInfoHandler haruka.RequestHandler = func(context *haruka.Context) {
+	accessToken, scheme := dpop.GetAccessToken(context.Request)
+	if scheme != dpop.TokenType {
+		accessToken = getBearerToken(context)
+	}
+	if accessToken == "" {
+		AbortBearerError(context, "", "", http.StatusUnauthorized)
+		return
+	}
+	// tokens bound to a DPoP key are only accepted with a proof of the key
+	jkt := ""
+	if scheme == dpop.TokenType {
+		var ok bool
+		jkt, ok = verifyResourceRequestProof(context, accessToken)
+		if !ok {
+			return
+		}
+	}
+	user, scope, err := service.GetUserInfo(accessToken, jkt)
+	if err != nil {
+		if scheme == dpop.TokenType {
+			AbortDPoPError(context, "invalid_token", err.Error(), http.StatusUnauthorized)
+			return
+		}
+		AbortBearerError(context, "invalid_token", err.Error(), http.StatusUnauthorized)
+		return
+	}
+	context.JSON(NewUserInfoTemplate(user, scope))
+}
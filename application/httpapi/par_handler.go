@@ -0,0 +1,42 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+var pushAuthorizationRequestHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+	app, ok := authenticateClient(context, context.Request.PostFormValue("client_id"), context.Request.PostFormValue("client_secret"))
+	if !ok {
+		return
+	}
+	request, err := service.PushAuthorizationRequest(app, context.Request.PostForm)
+	if err != nil {
+		switch err {
+		case service.InvalidateRedirectUri,
+			service.InvalidateParRequest,
+			service.CodeChallengeRequired,
+			service.InvalidateCodeChallengeMethod:
+			AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		case service.InvalidateRequestObject:
+			AbortOAuthError(context, "invalid_request_object", err.Error(), http.StatusBadRequest)
+		case service.UnsupportedResponseType:
+			AbortOAuthError(context, "unsupported_response_type", err.Error(), http.StatusBadRequest)
+		case service.InvalidateScope:
+			AbortOAuthError(context, "invalid_scope", err.Error(), http.StatusBadRequest)
+		case service.UnauthorizedClient:
+			AbortOAuthError(context, "unauthorized_client", err.Error(), http.StatusBadRequest)
+		default:
+			AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	context.JSONWithStatus(NewPushedAuthorizationTemplate(request), http.StatusCreated)
+}
@@ -0,0 +1,19 @@
+package httpapi
+
+import (
+	"time"
+
+	"github.com/projectxpolaris/youauth/database"
+)
+
+type PushedAuthorizationTemplate struct {
+	RequestUri string `json:"request_uri"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+func NewPushedAuthorizationTemplate(request *database.PushedAuthorizationRequest) PushedAuthorizationTemplate {
+	return PushedAuthorizationTemplate{
+		RequestUri: request.RequestUri,
+		ExpiresIn:  int64(time.Until(request.ExpiresAt).Seconds()),
+	}
+}
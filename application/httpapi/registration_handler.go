@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// ClientRegistrationData client metadata of RFC 7591 section 2, ClientId and ClientSecret are only sent on update
+type ClientRegistrationData struct {
+	RedirectUris                       []string        `json:"redirect_uris"`
+	TokenEndpointAuthMethod            string          `json:"token_endpoint_auth_method"`
+	GrantTypes                         []string        `json:"grant_types"`
+	ResponseTypes                      []string        `json:"response_types"`
+	ClientName                         string          `json:"client_name"`
+	Scope                              string          `json:"scope"`
+	Jwks                               json.RawMessage `json:"jwks"`
+	JwksUri                            string          `json:"jwks_uri"`
+	PostLogoutRedirectUris             []string        `json:"post_logout_redirect_uris"`
+	FrontchannelLogoutUri              string          `json:"frontchannel_logout_uri"`
+	BackchannelLogoutUri               string          `json:"backchannel_logout_uri"`
+	RequirePushedAuthorizationRequests bool            `json:"require_pushed_authorization_requests"`
+	ClientId                           string          `json:"client_id"`
+	ClientSecret                       string          `json:"client_secret"`
+}
+
+func (d ClientRegistrationData) getMetadata() service.ClientMetadata {
+	metadata := service.ClientMetadata{
+		RedirectUris:            d.RedirectUris,
+		TokenEndpointAuthMethod: d.TokenEndpointAuthMethod,
+		GrantTypes:              d.GrantTypes,
+		ResponseTypes:           d.ResponseTypes,
+		ClientName:              d.ClientName,
+		Scope:                   d.Scope,
+		JwksUri:                 d.JwksUri,
+		PostLogoutRedirectUris:  d.PostLogoutRedirectUris,
+		FrontchannelLogoutUri:   d.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    d.BackchannelLogoutUri,
+		RequirePar:              d.RequirePushedAuthorizationRequests,
+	}
+	if jwks := getRawJwks(d.Jwks); jwks != nil {
+		metadata.Jwks = *jwks
+	}
+	return metadata
+}
+
+// abortRegistrationError error response of the registration endpoints (RFC 7591 section 3.2.2)
+func abortRegistrationError(context *haruka.Context, err error) {
+	switch err {
+	case service.InvalidateInitialAccessToken, service.InvalidateRegistrationToken:
+		AbortBearerError(context, "invalid_token", err.Error(), http.StatusUnauthorized)
+	case service.InvalidateRedirectUri:
+		AbortOAuthError(context, "invalid_redirect_uri", err.Error(), http.StatusBadRequest)
+	case service.InvalidateClientMetadata,
+		service.InvalidateScope,
+		service.InvalidateClientAuthMethod,
+		service.InvalidateClientJwks,
+		service.InvalidateParRequirement:
+		AbortOAuthError(context, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+	default:
+		AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeClientInformation responses carrying client credentials must not be cached
+func writeClientInformation(context *haruka.Context, app *database.App, registrationToken string, status int) {
+	context.Writer.Header().Set("Cache-Control", "no-store")
+	context.Writer.Header().Set("Pragma", "no-cache")
+	context.JSONWithStatus(NewClientInformationTemplate(app, registrationToken), status)
+}
+
+var registerClientHandler haruka.RequestHandler = func(context *haruka.Context) {
+	initialAccessToken := getBearerToken(context)
+	if initialAccessToken == "" {
+		AbortBearerError(context, "", "", http.StatusUnauthorized)
+		return
+	}
+	var requestBody ClientRegistrationData
+	err := context.ParseJson(&requestBody)
+	if err != nil {
+		AbortOAuthError(context, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+		return
+	}
+	app, registrationToken, err := service.RegisterClient(initialAccessToken, requestBody.getMetadata())
+	if err != nil {
+		abortRegistrationError(context, err)
+		return
+	}
+	writeClientInformation(context, app, registrationToken, http.StatusCreated)
+}
+
+// clientConfigurationHandler client configuration endpoint of RFC 7592, authenticated by the registration access token
+var clientConfigurationHandler haruka.RequestHandler = func(context *haruka.Context) {
+	app, err := service.GetRegisteredClient(context.GetPathParameterAsString("clientid"), getBearerToken(context))
+	if err != nil {
+		abortRegistrationError(context, err)
+		return
+	}
+	switch context.Request.Method {
+	case http.MethodGet:
+		writeClientInformation(context, app, "", http.StatusOK)
+	case http.MethodPut:
+		var requestBody ClientRegistrationData
+		err = context.ParseJson(&requestBody)
+		if err != nil {
+			AbortOAuthError(context, "invalid_client_metadata", err.Error(), http.StatusBadRequest)
+			return
+		}
+		if requestBody.ClientId != app.AppId || (requestBody.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(requestBody.ClientSecret), []byte(app.Secret)) != 1) {
+			AbortOAuthError(context, "invalid_client_metadata", "client_id or client_secret does not match", http.StatusBadRequest)
+			return
+		}
+		app, err = service.UpdateRegisteredClient(app, requestBody.getMetadata())
+		if err != nil {
+			abortRegistrationError(context, err)
+			return
+		}
+		writeClientInformation(context, app, "", http.StatusOK)
+	case http.MethodDelete:
+		err = service.RemoveRegisteredClient(app)
+		if err != nil {
+			abortRegistrationError(context, err)
+			return
+		}
+		context.Writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var getInitialAccessTokenListHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	tokens, err := service.GetInitialAccessTokenList()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponseWithData(context, NewInitialAccessTokenTemplateList(tokens))
+}
+
+type CreateInitialAccessTokenData struct {
+	Description string `json:"description"`
+	// ExpiresIn lifetime in seconds, 0 for a token that never expires
+	ExpiresIn int64 `json:"expiresIn"`
+	// MaxUses number of clients the token can register, 0 for unlimited
+	MaxUses int `json:"maxUses"`
+}
+
+var createInitialAccessTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
+	user, ok := requireAdmin(context)
+	if !ok {
+		return
+	}
+	var requestBody CreateInitialAccessTokenData
+	err := context.ParseJson(&requestBody)
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	token, record, err := service.CreateInitialAccessToken(user.ID, requestBody.Description, requestBody.ExpiresIn, requestBody.MaxUses)
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	template := NewInitialAccessTokenTemplate(record)
+	template.Token = token
+	MakeSuccessResponseWithData(context, template)
+}
+
+var removeInitialAccessTokenHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	id, err := strconv.Atoi(context.GetPathParameterAsString("id"))
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	err = service.RemoveInitialAccessToken(uint(id))
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponse(context)
+}
@@ -0,0 +1,98 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// ClientInformationTemplate client information response of RFC 7591 section 3.2.1
+type ClientInformationTemplate struct {
+	ClientId                           string          `json:"client_id"`
+	ClientSecret                       string          `json:"client_secret,omitempty"`
+	ClientIdIssuedAt                   int64           `json:"client_id_issued_at"`
+	ClientSecretExpiresAt              *int64          `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken            string          `json:"registration_access_token,omitempty"`
+	RegistrationClientUri              string          `json:"registration_client_uri"`
+	ClientName                         string          `json:"client_name,omitempty"`
+	RedirectUris                       []string        `json:"redirect_uris"`
+	TokenEndpointAuthMethod            string          `json:"token_endpoint_auth_method"`
+	GrantTypes                         []string        `json:"grant_types"`
+	ResponseTypes                      []string        `json:"response_types"`
+	Scope                              string          `json:"scope"`
+	Jwks                               json.RawMessage `json:"jwks,omitempty"`
+	JwksUri                            string          `json:"jwks_uri,omitempty"`
+	PostLogoutRedirectUris             []string        `json:"post_logout_redirect_uris,omitempty"`
+	FrontchannelLogoutUri              string          `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutUri               string          `json:"backchannel_logout_uri,omitempty"`
+	RequirePushedAuthorizationRequests bool            `json:"require_pushed_authorization_requests"`
+}
+
+func NewClientInformationTemplate(app *database.App, registrationToken string) ClientInformationTemplate {
+	template := ClientInformationTemplate{
+		ClientId:                           app.AppId,
+		ClientIdIssuedAt:                   app.CreatedAt.Unix(),
+		RegistrationAccessToken:            registrationToken,
+		RegistrationClientUri:              config.Instance.JWTConfig.GetBaseUrl() + "/connect/register/" + app.AppId,
+		ClientName:                         app.Name,
+		RedirectUris:                       strings.Fields(app.RedirectUris),
+		TokenEndpointAuthMethod:            app.TokenEndpointAuthMethod,
+		GrantTypes:                         strings.Fields(app.GrantTypes),
+		ResponseTypes:                      strings.Fields(app.ResponseTypes),
+		Scope:                              strings.Join(service.GetAllowedScopes(app), " "),
+		JwksUri:                            app.JwksUri,
+		PostLogoutRedirectUris:             strings.Fields(app.PostLogoutRedirectUris),
+		FrontchannelLogoutUri:              app.FrontchannelLogoutUri,
+		BackchannelLogoutUri:               app.BackchannelLogoutUri,
+		RequirePushedAuthorizationRequests: app.RequirePar,
+	}
+	if template.TokenEndpointAuthMethod == "" {
+		template.TokenEndpointAuthMethod = service.ClientAuthMethodBasic
+	}
+	// only clients authenticating with the shared secret get it, the secret does not expire
+	switch template.TokenEndpointAuthMethod {
+	case service.ClientAuthMethodBasic, service.ClientAuthMethodPost:
+		neverExpires := int64(0)
+		template.ClientSecret = app.Secret
+		template.ClientSecretExpiresAt = &neverExpires
+	}
+	if app.Jwks != "" {
+		template.Jwks = json.RawMessage(app.Jwks)
+	}
+	return template
+}
+
+type InitialAccessTokenTemplate struct {
+	Id          uint   `json:"id"`
+	Token       string `json:"token,omitempty"`
+	Description string `json:"description"`
+	CreateAt    string `json:"createAt"`
+	ExpiresAt   string `json:"expiresAt,omitempty"`
+	MaxUses     int    `json:"maxUses"`
+	Uses        int    `json:"uses"`
+}
+
+func NewInitialAccessTokenTemplate(token *database.InitialAccessToken) InitialAccessTokenTemplate {
+	template := InitialAccessTokenTemplate{
+		Id:          token.ID,
+		Description: token.Description,
+		CreateAt:    token.CreatedAt.Format(timeFormat),
+		MaxUses:     token.MaxUses,
+		Uses:        token.Uses,
+	}
+	if token.ExpiresAt != nil {
+		template.ExpiresAt = token.ExpiresAt.Format(timeFormat)
+	}
+	return template
+}
+
+func NewInitialAccessTokenTemplateList(tokens []*database.InitialAccessToken) []InitialAccessTokenTemplate {
+	templates := make([]InitialAccessTokenTemplate, 0)
+	for _, token := range tokens {
+		templates = append(templates, NewInitialAccessTokenTemplate(token))
+	}
+	return templates
+}
@@ -1,8 +1,12 @@
 package httpapi
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/allentom/haruka"
 	"github.com/projectxpolaris/youauth/commons"
+	"github.com/projectxpolaris/youauth/dpop"
 	"github.com/projectxpolaris/youauth/plugins/youlog"
 	"github.com/projectxpolaris/youauth/service"
 )
@@ -61,3 +65,52 @@ func MakeListResponse(context *haruka.Context, data interface{}, total int64, pa
 func RaiseErrorHtml(ctx *haruka.Context) {
 	ctx.HTML("./templates/404.html", map[string]interface{}{})
 }
+
+// RaiseErrorPage render the reason on the error page, used when the error must not be sent to the client redirect uri
+func RaiseErrorPage(ctx *haruka.Context, err error, status int) {
+	ctx.Writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Writer.WriteHeader(status)
+	ctx.HTML("./templates/error.html", map[string]interface{}{
+		"Error": err.Error(),
+	})
+}
+
+// AbortOAuthError error response of the token endpoint (RFC 6749 section 5.2)
+func AbortOAuthError(ctx *haruka.Context, code string, description string, status int) {
+	if code == "invalid_client" {
+		ctx.Writer.Header().Set("WWW-Authenticate", `Basic realm="youauth"`)
+	}
+	ctx.JSONWithStatus(haruka.JSON{
+		"error":             code,
+		"error_description": description,
+	}, status)
+}
+
+// AbortBearerError error response of a protected resource (RFC 6750 section 3)
+func AbortBearerError(ctx *haruka.Context, code string, description string, status int) {
+	abortChallengeError(ctx, "Bearer", code, description, status)
+}
+
+// AbortDPoPError error response of a protected resource for requests using the DPoP scheme (RFC 9449 section 7.1)
+func AbortDPoPError(ctx *haruka.Context, code string, description string, status int) {
+	abortChallengeError(ctx, dpop.TokenType, code, description, status)
+}
+
+func abortChallengeError(ctx *haruka.Context, scheme string, code string, description string, status int) {
+	challenge := scheme + ` realm="youauth"`
+	if code != "" {
+		challenge += fmt.Sprintf(`, error="%s"`, code)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(`, error_description="%s"`, strings.ReplaceAll(description, `"`, `'`))
+	}
+	ctx.Writer.Header().Set("WWW-Authenticate", challenge)
+	if code == "" {
+		ctx.Writer.WriteHeader(status)
+		return
+	}
+	ctx.JSONWithStatus(haruka.JSON{
+		"error":             code,
+		"error_description": description,
+	}, status)
+}
@@ -0,0 +1,52 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+var getScopeListHandler haruka.RequestHandler = func(context *haruka.Context) {
+	scopes, err := service.GetScopeList()
+	if err != nil {
+		AbortError(context, err, http.StatusInternalServerError)
+		return
+	}
+	MakeSuccessResponseWithData(context, NewScopeTemplateList(scopes))
+}
+
+type CreateScopeData struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var createScopeHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	var requestBody CreateScopeData
+	err := context.ParseJson(&requestBody)
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	scope, err := service.CreateScope(requestBody.Name, requestBody.Description)
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	MakeSuccessResponseWithData(context, NewScopeTemplate(scope))
+}
+
+var removeScopeHandler haruka.RequestHandler = func(context *haruka.Context) {
+	if _, ok := requireAdmin(context); !ok {
+		return
+	}
+	err := service.RemoveScope(context.GetPathParameterAsString("name"))
+	if err != nil {
+		AbortError(context, err, http.StatusBadRequest)
+		return
+	}
+	MakeSuccessResponse(context)
+}
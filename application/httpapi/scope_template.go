@@ -0,0 +1,25 @@
+package httpapi
+
+import "github.com/projectxpolaris/youauth/database"
+
+type ScopeTemplate struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	BuiltIn     bool   `json:"builtIn"`
+}
+
+func NewScopeTemplate(scope *database.Scope) ScopeTemplate {
+	return ScopeTemplate{
+		Name:        scope.Name,
+		Description: scope.Description,
+		BuiltIn:     scope.ID == 0,
+	}
+}
+
+func NewScopeTemplateList(scopes []*database.Scope) []ScopeTemplate {
+	templates := make([]ScopeTemplate, 0)
+	for _, scope := range scopes {
+		templates = append(templates, NewScopeTemplate(scope))
+	}
+	return templates
+}
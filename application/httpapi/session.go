@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+const sessionCookieName = "youauth_session"
+
+func getSessionToken(context *haruka.Context) string {
+	cookie, err := context.Request.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// getCurrentSession single sign-on session of the browser, nil if there is none or it expired
+func getCurrentSession(context *haruka.Context) *database.Session {
+	token := getSessionToken(context)
+	if token == "" {
+		return nil
+	}
+	session, err := service.GetSession(token)
+	if err != nil {
+		return nil
+	}
+	return session
+}
+
+func setSessionCookie(context *haruka.Context, token string) {
+	http.SetCookie(context.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(config.Instance.SessionConfig.AbsoluteTimeout),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.Instance.JWTConfig.GetBaseUrl(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(context *haruka.Context) {
+	http.SetCookie(context.Writer, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.Instance.JWTConfig.GetBaseUrl(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// startSession replace the session of the browser with a new one for the user
+func startSession(context *haruka.Context, userId uint) (*database.Session, error) {
+	if token := getSessionToken(context); token != "" {
+		err := service.RemoveSession(token)
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, session, err := service.CreateSession(userId)
+	if err != nil {
+		return nil, err
+	}
+	setSessionCookie(context, token)
+	return session, nil
+}
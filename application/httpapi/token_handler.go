@@ -0,0 +1,104 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/allentom/haruka"
+	"github.com/projectxpolaris/youauth/service"
+)
+
+// abortTokenError error response of the token endpoint for errors of the grant (RFC 6749 section 5.2, RFC 8628 section 3.5)
+func abortTokenError(context *haruka.Context, err error) {
+	switch err {
+	case service.InvalidateUsernameOrPassword,
+		service.InvalidateAuthCode,
+		service.AuthCodeExpire,
+		service.AuthCodeReused,
+		service.InvalidateCodeVerifier,
+		service.InvalidateRedirectUri,
+		service.InvalidateTokenType,
+		service.TokenExpired,
+		service.TokenRevoked,
+		service.TokenNotOwnedByClient,
+		service.RefreshTokenReused,
+		service.InvalidateAppError,
+		service.InvalidateDeviceCode:
+		AbortOAuthError(context, "invalid_grant", err.Error(), http.StatusBadRequest)
+	case service.InvalidateScope:
+		AbortOAuthError(context, "invalid_scope", err.Error(), http.StatusBadRequest)
+	case service.UnauthorizedClient:
+		AbortOAuthError(context, "unauthorized_client", err.Error(), http.StatusBadRequest)
+	case service.AuthorizationPending:
+		AbortOAuthError(context, "authorization_pending", err.Error(), http.StatusBadRequest)
+	case service.SlowDown:
+		AbortOAuthError(context, "slow_down", err.Error(), http.StatusBadRequest)
+	case service.DeviceCodeExpired:
+		AbortOAuthError(context, "expired_token", err.Error(), http.StatusBadRequest)
+	case service.InvalidateSubjectToken, service.InvalidateActorToken, service.UnsupportedTokenType:
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+	case service.InvalidateTarget, service.UnsupportedRequestedTokenType:
+		AbortOAuthError(context, "invalid_target", err.Error(), http.StatusBadRequest)
+	case service.DPoPProofRequired, service.DPoPKeyMismatch:
+		AbortOAuthError(context, "invalid_dpop_proof", err.Error(), http.StatusBadRequest)
+	case service.AccessDenied:
+		AbortOAuthError(context, "access_denied", err.Error(), http.StatusBadRequest)
+	default:
+		AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var introspectHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+	app, ok := authenticateClient(context, context.Request.PostFormValue("client_id"), context.Request.PostFormValue("client_secret"))
+	if !ok {
+		return
+	}
+	// only confidential clients such as resource servers may introspect tokens
+	if service.IsPublicClient(app) {
+		AbortOAuthError(context, "invalid_client", "client authentication failed", http.StatusUnauthorized)
+		return
+	}
+	token := context.Request.PostFormValue("token")
+	if token == "" {
+		AbortOAuthError(context, "invalid_request", "token is required", http.StatusBadRequest)
+		return
+	}
+	introspection, err := service.IntrospectToken(token)
+	if err != nil {
+		AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context.JSON(NewIntrospectionTemplate(introspection))
+}
+
+var revokeHandler haruka.RequestHandler = func(context *haruka.Context) {
+	err := context.Request.ParseForm()
+	if err != nil {
+		AbortOAuthError(context, "invalid_request", err.Error(), http.StatusBadRequest)
+		return
+	}
+	app, ok := authenticateClient(context, context.Request.PostFormValue("client_id"), context.Request.PostFormValue("client_secret"))
+	if !ok {
+		return
+	}
+	token := context.Request.PostFormValue("token")
+	if token == "" {
+		AbortOAuthError(context, "invalid_request", "token is required", http.StatusBadRequest)
+		return
+	}
+	// token_type_hint is optional, the type is read from the token itself
+	err = service.RevokeToken(token, app)
+	if err == service.TokenNotOwnedByClient {
+		AbortOAuthError(context, "unauthorized_client", err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		AbortOAuthError(context, "server_error", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	context.Writer.WriteHeader(http.StatusOK)
+}
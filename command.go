@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/projectxpolaris/youauth/config"
+)
+
+// runRotateKeyCommand ask the running service to rotate the signing key through the admin api
+//
+//	youauth rotate-key -url http://localhost:8602 -token <admin access token>
+func runRotateKeyCommand(args []string) error {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	serviceUrl := flags.String("url", config.Instance.JWTConfig.GetBaseUrl(), "url of the running YouAuth service")
+	token := flags.String("token", os.Getenv("YOUAUTH_ADMIN_TOKEN"), "access token of an admin user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *serviceUrl == "" || *token == "" {
+		return errors.New("url and token are required")
+	}
+	request, err := http.NewRequest(http.MethodPost, *serviceUrl+"/admin/keys/rotate", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+*token)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var result struct {
+		Success bool   `json:"success"`
+		Err     string `json:"err"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("rotate signing key failed: %s", result.Err)
+	}
+	fmt.Println("signing key rotated")
+	return nil
+}
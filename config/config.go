@@ -3,6 +3,8 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/allentom/harukap/config"
 )
@@ -21,17 +23,61 @@ func InitConfigProvider() error {
 var Instance Config
 
 type JWTConfig struct {
-	Secret             string `json:"secret"`
-	Issuer             string `json:"issuer"`
-	AccessTokenExpire  int64
-	RefreshTokenExpire int64
-	AuthCodeExpires    int64
-	AppTokenExpire     int64
-	Url                string
+	Secret              string `json:"secret"`
+	Issuer              string `json:"issuer"`
+	AccessTokenExpire   int64
+	RefreshTokenExpire  int64
+	AuthCodeExpires     int64
+	AppTokenExpire      int64
+	IdTokenExpire       int64
+	DeviceCodeExpire    int64
+	DeviceCodeInterval  int64
+	ParExpire           int64
+	DPoPRequireNonce    bool
+	Url                 string
+	SigningAlgorithm    string
+	SigningKeyFile      string
+	KeyRotationInterval int64
+	KeyRetirePeriod     int64
+	LegacyHS256Until    string
 }
+
+// GetBaseUrl 返回服务对外访问的根地址，不包含结尾的 /
+func (c JWTConfig) GetBaseUrl() string {
+	return strings.TrimSuffix(c.Url, "/")
+}
+
+// GetIssuer 返回 OIDC 中使用的 issuer，token.issuer 为 URL 时直接使用，否则使用 token.url
+func (c JWTConfig) GetIssuer() string {
+	if strings.HasPrefix(c.Issuer, "http://") || strings.HasPrefix(c.Issuer, "https://") {
+		return strings.TrimSuffix(c.Issuer, "/")
+	}
+	if c.Url != "" {
+		return c.GetBaseUrl()
+	}
+	return c.Issuer
+}
+
+// GetLegacyHS256Until 返回使用非对称算法签名时仍接受旧 HS256 令牌的截止时间，未配置或格式错误时返回零值
+func (c JWTConfig) GetLegacyHS256Until() time.Time {
+	until, err := time.Parse(time.RFC3339, c.LegacyHS256Until)
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// SessionConfig 浏览器单点登录会话配置
+type SessionConfig struct {
+	IdleTimeout     int64
+	AbsoluteTimeout int64
+}
+
 type Config struct {
 	JWTConfig         JWTConfig
+	SessionConfig     SessionConfig
 	ExternalLoginPage string
+	Admins            []string
 }
 
 func ReadConfig(provider *config.Provider) {
@@ -39,19 +85,41 @@ func ReadConfig(provider *config.Provider) {
 	configer.SetDefault("addr", getEnvOrDefault("YOUAUTH_ADDR", ":8000"))
 	configer.SetDefault("application", getEnvOrDefault("YOUAUTH_APPLICATION", "You Auth Service"))
 	configer.SetDefault("instance", getEnvOrDefault("YOUAUTH_INSTANCE", "main"))
+	configer.SetDefault("token.signingAlgorithm", "RS256")
+	configer.SetDefault("token.idTokenExpiresIn", 3600)
+	configer.SetDefault("token.deviceCodeExpiresIn", 600)
+	configer.SetDefault("token.deviceCodeInterval", 5)
+	configer.SetDefault("token.parExpiresIn", 90)
+	configer.SetDefault("session.idleTimeout", 86400)
+	configer.SetDefault("session.absoluteTimeout", 604800)
 
 	// 从环境变量读取配置，如果环境变量存在则优先使用环境变量的值
 	Instance = Config{
 		JWTConfig: JWTConfig{
-			Secret:             getEnvOrDefault("YOUAUTH_TOKEN_SECRET", configer.GetString("token.secret")),
-			Issuer:             getEnvOrDefault("YOUAUTH_TOKEN_ISSUER", configer.GetString("token.issuer")),
-			AccessTokenExpire:  getEnvInt64OrDefault("YOUAUTH_TOKEN_ACCESS_EXPIRES", configer.GetInt64("token.accessTokenExpiresIn")),
-			RefreshTokenExpire: getEnvInt64OrDefault("YOUAUTH_TOKEN_REFRESH_EXPIRES", configer.GetInt64("token.refreshTokenExpiresIn")),
-			AuthCodeExpires:    getEnvInt64OrDefault("YOUAUTH_TOKEN_AUTH_CODE_EXPIRES", configer.GetInt64("token.authCodeExpiresIn")),
-			AppTokenExpire:     getEnvInt64OrDefault("YOUAUTH_TOKEN_APP_EXPIRES", configer.GetInt64("token.appTokenExpiresIn")),
-			Url:                getEnvOrDefault("YOUAUTH_TOKEN_URL", configer.GetString("token.url")),
+			Secret:              getEnvOrDefault("YOUAUTH_TOKEN_SECRET", configer.GetString("token.secret")),
+			Issuer:              getEnvOrDefault("YOUAUTH_TOKEN_ISSUER", configer.GetString("token.issuer")),
+			AccessTokenExpire:   getEnvInt64OrDefault("YOUAUTH_TOKEN_ACCESS_EXPIRES", configer.GetInt64("token.accessTokenExpiresIn")),
+			RefreshTokenExpire:  getEnvInt64OrDefault("YOUAUTH_TOKEN_REFRESH_EXPIRES", configer.GetInt64("token.refreshTokenExpiresIn")),
+			AuthCodeExpires:     getEnvInt64OrDefault("YOUAUTH_TOKEN_AUTH_CODE_EXPIRES", configer.GetInt64("token.authCodeExpiresIn")),
+			AppTokenExpire:      getEnvInt64OrDefault("YOUAUTH_TOKEN_APP_EXPIRES", configer.GetInt64("token.appTokenExpiresIn")),
+			IdTokenExpire:       getEnvInt64OrDefault("YOUAUTH_TOKEN_ID_TOKEN_EXPIRES", configer.GetInt64("token.idTokenExpiresIn")),
+			DeviceCodeExpire:    getEnvInt64OrDefault("YOUAUTH_TOKEN_DEVICE_CODE_EXPIRES", configer.GetInt64("token.deviceCodeExpiresIn")),
+			DeviceCodeInterval:  getEnvInt64OrDefault("YOUAUTH_TOKEN_DEVICE_CODE_INTERVAL", configer.GetInt64("token.deviceCodeInterval")),
+			ParExpire:           getEnvInt64OrDefault("YOUAUTH_TOKEN_PAR_EXPIRES", configer.GetInt64("token.parExpiresIn")),
+			DPoPRequireNonce:    getEnvBoolOrDefault("YOUAUTH_TOKEN_DPOP_REQUIRE_NONCE", configer.GetBool("token.dpopRequireNonce")),
+			Url:                 getEnvOrDefault("YOUAUTH_TOKEN_URL", configer.GetString("token.url")),
+			SigningAlgorithm:    getEnvOrDefault("YOUAUTH_TOKEN_SIGNING_ALGORITHM", configer.GetString("token.signingAlgorithm")),
+			SigningKeyFile:      getEnvOrDefault("YOUAUTH_TOKEN_SIGNING_KEY_FILE", configer.GetString("token.signingKeyFile")),
+			KeyRotationInterval: getEnvInt64OrDefault("YOUAUTH_TOKEN_KEY_ROTATION_INTERVAL", configer.GetInt64("token.keyRotationInterval")),
+			KeyRetirePeriod:     getEnvInt64OrDefault("YOUAUTH_TOKEN_KEY_RETIRE_PERIOD", configer.GetInt64("token.keyRetirePeriod")),
+			LegacyHS256Until:    getEnvOrDefault("YOUAUTH_TOKEN_LEGACY_HS256_UNTIL", configer.GetString("token.legacyHs256Until")),
+		},
+		SessionConfig: SessionConfig{
+			IdleTimeout:     getEnvInt64OrDefault("YOUAUTH_SESSION_IDLE_TIMEOUT", configer.GetInt64("session.idleTimeout")),
+			AbsoluteTimeout: getEnvInt64OrDefault("YOUAUTH_SESSION_ABSOLUTE_TIMEOUT", configer.GetInt64("session.absoluteTimeout")),
 		},
 		ExternalLoginPage: getEnvOrDefault("YOUAUTH_EXTERNAL_LOGIN_PAGE", configer.GetString("externalLoginPage")),
+		Admins:            getEnvSliceOrDefault("YOUAUTH_ADMINS", configer.GetStringSlice("admins")),
 	}
 }
 
@@ -63,6 +131,30 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvSliceOrDefault 从环境变量获取以逗号分隔的字符串列表，如果不存在则返回默认值
+func getEnvSliceOrDefault(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		result := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				result = append(result, item)
+			}
+		}
+		return result
+	}
+	return defaultValue
+}
+
+// getEnvBoolOrDefault 从环境变量获取布尔值，如果不存在或转换失败则返回默认值
+func getEnvBoolOrDefault(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 // getEnvInt64OrDefault 从环境变量获取int64值，如果不存在或转换失败则返回默认值
 func getEnvInt64OrDefault(key string, defaultValue int64) int64 {
 	if value := os.Getenv(key); value != "" {
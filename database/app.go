@@ -4,9 +4,37 @@ import "gorm.io/gorm"
 
 type App struct {
 	gorm.Model
-	AppId    string
-	Name     string
-	Callback string
-	Secret   string
-	UserId   *uint
+	AppId       string
+	Name        string
+	Callback    string
+	Secret      string
+	UserId      *uint
+	RequirePkce bool
+	// RequirePar authorization parameters must be pushed to /par first
+	RequirePar        bool
+	RedirectUris      string
+	AllowLoopbackPort bool
+	// TokenEndpointAuthMethod client_secret_basic, client_secret_post or none for public clients
+	TokenEndpointAuthMethod string
+	// AllowedScopes space separated scopes the app may request for users, empty allows the built-in scopes
+	AllowedScopes string
+	// ClientCredentialsScopes space separated scopes the app may request for itself by client_credentials grant
+	ClientCredentialsScopes string
+	// FirstParty apps run by the server owner, users are not asked for consent
+	FirstParty bool
+	// PostLogoutRedirectUris space separated uris the user may be sent to after logout
+	PostLogoutRedirectUris string
+	FrontchannelLogoutUri  string
+	BackchannelLogoutUri   string
+	// TokenExchangeAudiences space separated audiences the app may exchange tokens for, set by admins
+	TokenExchangeAudiences string
+	// Jwks inline JWK set of the app, used for private_key_jwt and signed request objects
+	Jwks    string
+	JwksUri string
+	// GrantTypes space separated grant types the app may use, empty allows every grant
+	GrantTypes string
+	// ResponseTypes space separated response types the app may request, empty allows every response type
+	ResponseTypes string
+	// RegistrationTokenHash hash of the registration access token of a dynamically registered app (RFC 7592)
+	RegistrationTokenHash string `gorm:"size:64"`
 }
@@ -0,0 +1,15 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UsedClientAssertion jti of a client assertion, kept until the assertion expires to reject replays
+type UsedClientAssertion struct {
+	gorm.Model
+	AppId     uint      `gorm:"uniqueIndex:idx_client_assertion_jti"`
+	Jti       string    `gorm:"uniqueIndex:idx_client_assertion_jti;size:128"`
+	ExpiresAt time.Time `gorm:"index"`
+}
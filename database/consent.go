@@ -0,0 +1,39 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Consent scopes the user has granted to the app
+type Consent struct {
+	gorm.Model
+	UserId uint `gorm:"index"`
+	AppId  uint `gorm:"index"`
+	Scope  string
+}
+
+// AuthorizationRequest authorization request of an authenticated user waiting for consent
+type AuthorizationRequest struct {
+	gorm.Model
+	RequestId           string `gorm:"uniqueIndex;size:64"`
+	AppId               *uint
+	UserId              *uint
+	App                 *App
+	RedirectUri         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            *time.Time
+	Sid                 string
+	State               string
+	// CsrfToken rendered into the consent page, the answer must carry it
+	CsrfToken string
+	// TicketHash hash of the ticket in the consent url, binds requests made without a session
+	TicketHash string
+	// Ticket plain ticket, only known to the caller which created the request
+	Ticket    string `gorm:"-"`
+	ExpiresAt time.Time
+}
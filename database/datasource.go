@@ -8,6 +8,6 @@ import (
 var DefaultPlugin = &datasource.Plugin{
 	OnConnected: func(db *gorm.DB) {
 		Instance = db
-		Instance.AutoMigrate(&User{}, &App{}, &AuthorizationCode{})
+		Instance.AutoMigrate(&User{}, &App{}, &AuthorizationCode{}, &SigningKey{}, &IssuedToken{}, &Scope{}, &Consent{}, &AuthorizationRequest{}, &DeviceCode{}, &Session{}, &SessionApp{}, &PushedAuthorizationRequest{}, &UsedClientAssertion{}, &InitialAccessToken{}, &BackchannelLogout{})
 	},
 }
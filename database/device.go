@@ -0,0 +1,30 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DeviceCodeStatePending  = "pending"
+	DeviceCodeStateApproved = "approved"
+	DeviceCodeStateDenied   = "denied"
+)
+
+// DeviceCode device authorization waiting for the user to enter the user code (RFC 8628)
+type DeviceCode struct {
+	gorm.Model
+	DeviceCode   string `gorm:"uniqueIndex;size:64"`
+	UserCode     string `gorm:"uniqueIndex;size:16"`
+	AppId        *uint
+	App          *App
+	UserId       *uint
+	User         *User
+	Scope        string
+	State        string `gorm:"default:pending"`
+	Interval     int64
+	LastPolledAt *time.Time
+	AuthTime     *time.Time
+	ExpiresAt    time.Time
+}
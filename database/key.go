@@ -0,0 +1,24 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SigningKeyStateNext     = "next"
+	SigningKeyStateActive   = "active"
+	SigningKeyStateRetiring = "retiring"
+	SigningKeyStateRevoked  = "revoked"
+)
+
+type SigningKey struct {
+	gorm.Model
+	Kid         string `gorm:"uniqueIndex;size:64"`
+	Algorithm   string
+	PrivateKey  string
+	State       string `gorm:"default:active"`
+	ActivatedAt *time.Time
+	RetiredAt   *time.Time
+}
@@ -0,0 +1,17 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PushedAuthorizationRequest authorization parameters pushed by the client, referenced from /login by RequestUri (RFC 9126)
+type PushedAuthorizationRequest struct {
+	gorm.Model
+	RequestUri string `gorm:"uniqueIndex;size:128"`
+	AppId      *uint  `gorm:"index"`
+	// Parameters url encoded authorization request parameters
+	Parameters string
+	ExpiresAt  time.Time
+}
@@ -0,0 +1,20 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InitialAccessToken token issued by an admin to register clients through dynamic client registration (RFC 7591)
+type InitialAccessToken struct {
+	gorm.Model
+	TokenHash   string `gorm:"uniqueIndex;size:64"`
+	UserId      *uint
+	Description string
+	// ExpiresAt nil for a token that never expires
+	ExpiresAt *time.Time
+	// MaxUses number of clients the token can register, 0 for unlimited
+	MaxUses int
+	Uses    int
+}
@@ -0,0 +1,10 @@
+package database
+
+import "gorm.io/gorm"
+
+// Scope custom scope registered by admin, built-in scopes are not stored
+type Scope struct {
+	gorm.Model
+	Name        string `gorm:"uniqueIndex;size:128"`
+	Description string
+}
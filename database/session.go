@@ -0,0 +1,40 @@
+package database
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session browser single sign-on session, only the hash of the cookie value is stored
+type Session struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex;size:64"`
+	// Sid public identifier of the session, sent to apps in id_token and logout notifications
+	Sid          string `gorm:"uniqueIndex;size:64"`
+	UserId       *uint
+	User         *User
+	AuthTime     time.Time
+	LastActiveAt time.Time
+	ExpiresAt    time.Time
+}
+
+// BackchannelLogout pending back-channel logout notification of an ended session, kept until it is delivered or given up
+type BackchannelLogout struct {
+	gorm.Model
+	AppId         *uint
+	App           *App
+	UserId        *uint
+	User          *User
+	Sid           string
+	Attempts      int
+	NextAttemptAt time.Time `gorm:"index"`
+}
+
+// SessionApp app which received an auth code in the session, notified when the session ends
+type SessionApp struct {
+	gorm.Model
+	SessionId uint `gorm:"index"`
+	AppId     uint
+	App       *App
+}
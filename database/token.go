@@ -1,12 +1,39 @@
 package database
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type AuthorizationCode struct {
 	gorm.Model
-	Code   string
-	AppId  *uint
-	UserId *uint
-	User   *User
-	App    *App
+	Code                string
+	AppId               *uint
+	UserId              *uint
+	User                *User
+	App                 *App
+	RedirectUri         string
+	Scope               string
+	Nonce               string
+	AuthTime            *time.Time
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Sid                 string
+}
+
+// IssuedToken identifier of an issued token, used to revoke tokens before they expire
+type IssuedToken struct {
+	gorm.Model
+	Jti        string `gorm:"uniqueIndex;size:64"`
+	Type       string
+	UserId     *uint
+	AppId      *uint
+	AuthCodeId *uint `gorm:"index"`
+	// FamilyId tokens issued by the same grant and all refreshes of it share the family
+	FamilyId  string `gorm:"index;size:64"`
+	ExpiresAt time.Time
+	Revoked   bool
+	// Rotated the refresh token has been exchanged, using it again means it was leaked
+	Rotated bool
 }
@@ -1,10 +1,17 @@
 package database
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email"`
 	Apps     []*App
+	// TokensValidAfter tokens of the user issued before this time are rejected, including untracked tokens
+	TokensValidAfter *time.Time `json:"tokensValidAfter"`
 }
@@ -0,0 +1,56 @@
+package dpop
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+)
+
+// NonceSource stateless server nonces (RFC 9449 section 8), a nonce is valid in its time window and the next one.
+// Instances sharing the key accept each other's nonces
+type NonceSource struct {
+	key    []byte
+	window time.Duration
+}
+
+// NewNonceSource nonce source with the HMAC key, a random key is generated when key is empty
+func NewNonceSource(key []byte, window time.Duration) *NonceSource {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &NonceSource{key: key, window: window}
+}
+
+func (s *NonceSource) nonceOf(index int64) string {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(index))
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *NonceSource) currentIndex() int64 {
+	return time.Now().UnixNano() / int64(s.window)
+}
+
+// Current nonce the client should use for its next proof
+func (s *NonceSource) Current() string {
+	return s.nonceOf(s.currentIndex())
+}
+
+// Valid whether the nonce is issued in the current or the previous window
+func (s *NonceSource) Valid(nonce string) bool {
+	index := s.currentIndex()
+	for _, i := range []int64{index, index - 1} {
+		if hmac.Equal([]byte(nonce), []byte(s.nonceOf(i))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+// Package dpop verifies DPoP proofs (RFC 9449). It is used by the token endpoint and can be reused by
+// resource servers to check that a DPoP-bound access token is presented by the holder of the key.
+package dpop
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/util"
+)
+
+const (
+	HeaderName      = "DPoP"
+	NonceHeaderName = "DPoP-Nonce"
+	// TokenType token_type of access tokens bound to a DPoP key
+	TokenType = "DPoP"
+	proofType = "dpop+jwt"
+	// DefaultMaxAge proofs issued earlier than this are refused
+	DefaultMaxAge = 5 * time.Minute
+	// clockSkew proofs issued slightly in the future are accepted
+	clockSkew = 30 * time.Second
+)
+
+var (
+	InvalidateProof   = errors.New("invalid dpop proof")
+	ProofReplayed     = errors.New("dpop proof has been used before")
+	NonceRequired     = errors.New("dpop nonce required")
+	InvalidateNonce   = errors.New("invalid or expired dpop nonce")
+	KeyMismatch       = errors.New("dpop key does not match the key the token is bound to")
+	MultipleProofs    = errors.New("only one dpop proof is allowed")
+	UnsupportedAlg    = errors.New("unsupported dpop proof algorithm")
+	privateKeyMembers = []string{"d", "p", "q", "dp", "dq", "qi", "k"}
+)
+
+// Proof verified DPoP proof, Jkt is the JWK thumbprint of its key
+type Proof struct {
+	Jkt      string
+	Jti      string
+	Nonce    string
+	IssuedAt time.Time
+}
+
+// VerifyOption the request the proof has to match
+type VerifyOption struct {
+	Method string
+	// Url the http uri of the request, query and fragment are ignored
+	Url string
+	// AccessToken the proof must carry its hash (ath) when presented to a resource server
+	AccessToken string
+	// Nonces validates the nonce of the proof, nonces are not checked when nil
+	Nonces *NonceSource
+	// RequireNonce refuse proofs without nonce, only used with Nonces
+	RequireNonce bool
+	// Replay rejects proofs whose jti has been seen before, replays are not checked when nil
+	Replay *JtiCache
+	// MaxAge DefaultMaxAge when zero
+	MaxAge time.Duration
+}
+
+type proofClaims struct {
+	Jti   string `json:"jti"`
+	Htm   string `json:"htm"`
+	Htu   string `json:"htu"`
+	Iat   int64  `json:"iat"`
+	Ath   string `json:"ath,omitempty"`
+	Nonce string `json:"nonce,omitempty"`
+}
+
+func (c *proofClaims) Valid() error {
+	return nil
+}
+
+// AccessTokenHash ath claim for the access token
+func AccessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// normalizeUrl htu is compared without query and fragment (RFC 9449 section 4.3)
+func normalizeUrl(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), nil
+}
+
+// parseProofKey public key in the jwk header, private keys are refused
+func parseProofKey(token *jwt.Token) (*util.JWK, error) {
+	raw, ok := token.Header["jwk"].(map[string]interface{})
+	if !ok {
+		return nil, InvalidateProof
+	}
+	for _, member := range privateKeyMembers {
+		if _, ok := raw[member]; ok {
+			return nil, InvalidateProof
+		}
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	key := &util.JWK{}
+	err = json.Unmarshal(buf, key)
+	if err != nil {
+		return nil, InvalidateProof
+	}
+	return key, nil
+}
+
+// VerifyProof check the DPoP proof JWT against the request (RFC 9449 section 4.3)
+func VerifyProof(proof string, option VerifyOption) (*Proof, error) {
+	claims := &proofClaims{}
+	var key *util.JWK
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != proofType {
+			return nil, InvalidateProof
+		}
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *util.SigningMethodEd25519:
+		default:
+			return nil, UnsupportedAlg
+		}
+		var err error
+		key, err = parseProofKey(token)
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey()
+	})
+	if err != nil || !token.Valid {
+		return nil, InvalidateProof
+	}
+	if claims.Jti == "" || claims.Htm != option.Method {
+		return nil, InvalidateProof
+	}
+	htu, err := normalizeUrl(claims.Htu)
+	if err != nil {
+		return nil, InvalidateProof
+	}
+	expectedUrl, err := normalizeUrl(option.Url)
+	if err != nil || htu != expectedUrl {
+		return nil, InvalidateProof
+	}
+	maxAge := option.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxAge
+	}
+	issuedAt := time.Unix(claims.Iat, 0)
+	now := time.Now()
+	if claims.Iat == 0 || issuedAt.After(now.Add(clockSkew)) || issuedAt.Before(now.Add(-maxAge)) {
+		return nil, InvalidateProof
+	}
+	if option.AccessToken != "" && claims.Ath != AccessTokenHash(option.AccessToken) {
+		return nil, InvalidateProof
+	}
+	if option.Nonces != nil {
+		if claims.Nonce == "" && option.RequireNonce {
+			return nil, NonceRequired
+		}
+		if claims.Nonce != "" && !option.Nonces.Valid(claims.Nonce) {
+			return nil, InvalidateNonce
+		}
+	}
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return nil, InvalidateProof
+	}
+	if option.Replay != nil && !option.Replay.Use(jkt+":"+claims.Jti, issuedAt.Add(maxAge+clockSkew)) {
+		return nil, ProofReplayed
+	}
+	return &Proof{Jkt: jkt, Jti: claims.Jti, Nonce: claims.Nonce, IssuedAt: issuedAt}, nil
+}
+
+// GetProof the DPoP header of the request, empty when the request has no proof
+func GetProof(r *http.Request) (string, error) {
+	values := r.Header.Values(HeaderName)
+	if len(values) > 1 {
+		return "", MultipleProofs
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+	return values[0], nil
+}
+
+// GetAccessToken access token of the Authorization header with its scheme, Bearer or DPoP
+func GetAccessToken(r *http.Request) (string, string) {
+	authorization := r.Header.Get("Authorization")
+	index := strings.Index(authorization, " ")
+	if index < 0 {
+		return "", ""
+	}
+	scheme := authorization[:index]
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		scheme = "Bearer"
+	case strings.EqualFold(scheme, TokenType):
+		scheme = TokenType
+	default:
+		return "", ""
+	}
+	return strings.TrimSpace(authorization[index+1:]), scheme
+}
+
+// VerifyRequest check a request to a protected resource carrying the DPoP-bound access token.
+// requestUrl is the public url of the resource, jkt the thumbprint the token is bound to (its cnf.jkt claim)
+func VerifyRequest(r *http.Request, requestUrl string, accessToken string, jkt string, option VerifyOption) (*Proof, error) {
+	proof, err := GetProof(r)
+	if err != nil {
+		return nil, err
+	}
+	if proof == "" {
+		return nil, InvalidateProof
+	}
+	option.Method = r.Method
+	option.Url = requestUrl
+	option.AccessToken = accessToken
+	result, err := VerifyProof(proof, option)
+	if err != nil {
+		return nil, err
+	}
+	if result.Jkt != jkt {
+		return nil, KeyMismatch
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package dpop
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/util"
+)
+
+const testUrl = "https://auth.example.com/token"
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, map[string]interface{}) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := util.NewJWK(&key.PublicKey, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := map[string]interface{}{}
+	if err = json.Unmarshal(raw, &header); err != nil {
+		t.Fatal(err)
+	}
+	return key, header
+}
+
+func newTestProof(t *testing.T, key *ecdsa.PrivateKey, jwk map[string]interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = proofType
+	token.Header["jwk"] = jwk
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func newTestClaims(jti string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti": jti,
+		"htm": "POST",
+		"htu": testUrl,
+		"iat": time.Now().Unix(),
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	key, jwk := newTestKey(t)
+	expectedJkt, err := (&util.JWK{Kty: "EC", Crv: jwk["crv"].(string), X: jwk["x"].(string), Y: jwk["y"].(string)}).Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateJwk := map[string]interface{}{}
+	for name, value := range jwk {
+		privateJwk[name] = value
+	}
+	privateJwk["d"] = "bm90LWEtcmVhbC1wcml2YXRlLWtleQ"
+	nonces := NewNonceSource(nil, time.Minute)
+
+	tests := []struct {
+		name   string
+		jwk    map[string]interface{}
+		modify func(claims jwt.MapClaims)
+		option VerifyOption
+		err    error
+	}{
+		{name: "valid proof", jwk: jwk},
+		{name: "query of htu is ignored", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["htu"] = testUrl + "?a=b" }},
+		{name: "wrong htm", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["htm"] = "GET" }, err: InvalidateProof},
+		{name: "wrong htu", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["htu"] = "https://auth.example.com/userinfo" }, err: InvalidateProof},
+		{name: "stale iat", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["iat"] = time.Now().Add(-DefaultMaxAge - time.Minute).Unix() }, err: InvalidateProof},
+		{name: "iat in the future", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["iat"] = time.Now().Add(time.Hour).Unix() }, err: InvalidateProof},
+		{name: "missing jti", jwk: jwk, modify: func(claims jwt.MapClaims) { delete(claims, "jti") }, err: InvalidateProof},
+		{name: "jwk with private members", jwk: privateJwk, err: InvalidateProof},
+		{name: "missing ath", jwk: jwk, option: VerifyOption{AccessToken: "token"}, err: InvalidateProof},
+		{name: "matching ath", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["ath"] = AccessTokenHash("token") }, option: VerifyOption{AccessToken: "token"}},
+		{name: "missing required nonce", jwk: jwk, option: VerifyOption{Nonces: nonces, RequireNonce: true}, err: NonceRequired},
+		{name: "invalid nonce", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["nonce"] = "unknown" }, option: VerifyOption{Nonces: nonces}, err: InvalidateNonce},
+		{name: "valid nonce", jwk: jwk, modify: func(claims jwt.MapClaims) { claims["nonce"] = nonces.Current() }, option: VerifyOption{Nonces: nonces, RequireNonce: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := newTestClaims(test.name)
+			if test.modify != nil {
+				test.modify(claims)
+			}
+			option := test.option
+			option.Method = "POST"
+			option.Url = testUrl
+			proof, err := VerifyProof(newTestProof(t, key, test.jwk, claims), option)
+			if err != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if err == nil && proof.Jkt != expectedJkt {
+				t.Fatalf("expected jkt %s, got %s", expectedJkt, proof.Jkt)
+			}
+		})
+	}
+}
+
+func TestVerifyProofReplay(t *testing.T) {
+	key, jwk := newTestKey(t)
+	proof := newTestProof(t, key, jwk, newTestClaims("replayed"))
+	option := VerifyOption{Method: "POST", Url: testUrl, Replay: NewJtiCache()}
+	if _, err := VerifyProof(proof, option); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := VerifyProof(proof, option); err != ProofReplayed {
+		t.Fatalf("expected %v, got %v", ProofReplayed, err)
+	}
+}
+
+// TestThumbprint example of RFC 7638 section 3.1
+func TestThumbprint(t *testing.T) {
+	key := &util.JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+		Alg: "RS256",
+		Kid: "2011-04-29",
+	}
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jkt != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint %s", jkt)
+	}
+}
@@ -0,0 +1,40 @@
+package dpop
+
+import (
+	"sync"
+	"time"
+)
+
+// purgeInterval expired entries are dropped at most this often
+const purgeInterval = time.Minute
+
+// JtiCache in-memory record of proof identifiers, kept until the proof would be refused for its age anyway
+type JtiCache struct {
+	lock     sync.Mutex
+	entries  map[string]time.Time
+	purgedAt time.Time
+}
+
+func NewJtiCache() *JtiCache {
+	return &JtiCache{entries: map[string]time.Time{}}
+}
+
+// Use record the jti until expiresAt, false if it has been recorded before
+func (c *JtiCache) Use(jti string, expiresAt time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	if now.Sub(c.purgedAt) > purgeInterval {
+		for key, expires := range c.entries {
+			if now.After(expires) {
+				delete(c.entries, key)
+			}
+		}
+		c.purgedAt = now
+	}
+	if expires, ok := c.entries[jti]; ok && now.Before(expires) {
+		return false
+	}
+	c.entries[jti] = expiresAt
+	return true
+}
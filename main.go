@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"os"
+
 	"github.com/allentom/harukap"
 	"github.com/allentom/harukap/cli"
 	"github.com/allentom/harukap/plugins/nacos"
@@ -8,6 +11,7 @@ import (
 	"github.com/projectxpolaris/youauth/config"
 	"github.com/projectxpolaris/youauth/database"
 	"github.com/projectxpolaris/youauth/plugins/youlog"
+	"github.com/projectxpolaris/youauth/service"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,6 +20,12 @@ func main() {
 	if err != nil {
 		logrus.Fatal(err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		if err = runRotateKeyCommand(os.Args[2:]); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
 	err = youlog.DefaultYouLogPlugin.OnInit(config.DefaultConfigProvider)
 	if err != nil {
 		logrus.Fatal(err)
@@ -36,6 +46,8 @@ func main() {
 	}
 	appEngine.UsePlugin(database.DefaultPlugin)
 	appEngine.HttpService = httpapi.GetEngine()
+	service.RunKeyRotationScheduler()
+	service.RunBackchannelLogoutWorker(context.Background())
 	if err != nil {
 		logrus.Fatal(err)
 	}
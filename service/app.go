@@ -2,6 +2,7 @@ package service
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -15,34 +16,142 @@ import (
 var (
 	InvalidateAppError = errors.New("invalidate app")
 	AuthCodeExpire     = errors.New("auth code expire")
+	AuthCodeReused     = errors.New("auth code already used")
+	InvalidateAuthCode = errors.New("invalid auth code")
+	InvalidateScope    = errors.New("invalid scope")
+	UnauthorizedClient = errors.New("client is not authorized to use this grant type")
 )
 
 type AuthClaim struct {
 	jwt.StandardClaims
-	Type string `json:"type"`
+	Type     string `json:"type"`
+	Scope    string `json:"scope,omitempty"`
+	Username string `json:"username,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	// Act party acting on behalf of the subject, set on tokens issued by token exchange
+	Act *ActorClaim `json:"act,omitempty"`
+	// Cnf key the token is bound to, a DPoP proof of the key must accompany the token
+	Cnf *ConfirmationClaim `json:"cnf,omitempty"`
+	// legacyHash hash of the raw token, only set on tokens issued before jti was introduced
+	legacyHash string
 }
 
-func CreateApp(name string, callbackUrl string, userId uint) (*database.App, error) {
+// GetUsername username of the token owner, empty for tokens issued to the client itself.
+// Tokens issued before jti was introduced keep the username in jti
+func (c *AuthClaim) GetUsername() string {
+	if c.Username != "" {
+		return c.Username
+	}
+	if c.ClientId != "" {
+		return ""
+	}
+	return c.Id
+}
+
+// trackingId identifier the token is tracked by. Tokens issued before jti was introduced share the username as jti,
+// they are tracked by the hash of the token instead
+func (c *AuthClaim) trackingId() string {
+	if c.legacyHash != "" {
+		return c.legacyHash
+	}
+	return c.Id
+}
+
+// GetClientId app the token is issued to
+func (c *AuthClaim) GetClientId() string {
+	if c.ClientId != "" || c.Subject == "self" {
+		return c.ClientId
+	}
+	return c.Subject
+}
+
+// CreateAppOption settings of the new app
+type CreateAppOption struct {
+	Name                    string
+	Callback                string
+	RedirectUris            []string
+	AllowLoopbackPort       bool
+	RequirePkce             bool
+	RequirePar              bool
+	TokenEndpointAuthMethod string
+	AllowedScopes           []string
+	ClientCredentialsScopes []string
+	FirstParty              bool
+	PostLogoutRedirectUris  []string
+	FrontchannelLogoutUri   string
+	BackchannelLogoutUri    string
+	TokenExchangeAudiences  []string
+	Jwks                    string
+	JwksUri                 string
+	GrantTypes              []string
+	ResponseTypes           []string
+}
+
+func CreateApp(option CreateAppOption, userId uint) (*database.App, error) {
+	return createApp(database.Instance, option, userId)
+}
+
+// createApp validate the option and insert the app with tx
+func createApp(tx *gorm.DB, option CreateAppOption, userId uint) (*database.App, error) {
+	err := checkRedirectUris(option.RedirectUris)
+	if err != nil {
+		return nil, err
+	}
+	err = checkClientAuthMethod(option.TokenEndpointAuthMethod)
+	if err != nil {
+		return nil, err
+	}
+	err = checkScopes(append(option.AllowedScopes, option.ClientCredentialsScopes...))
+	if err != nil {
+		return nil, err
+	}
+	err = checkLogoutUris(option.PostLogoutRedirectUris, option.FrontchannelLogoutUri, option.BackchannelLogoutUri)
+	if err != nil {
+		return nil, err
+	}
 	app := database.App{
-		Name:     name,
-		AppId:    xid.New().String(),
-		Callback: callbackUrl,
-		UserId:   &userId,
+		Name:                    option.Name,
+		AppId:                   xid.New().String(),
+		Callback:                option.Callback,
+		UserId:                  &userId,
+		RequirePkce:             option.RequirePkce,
+		RequirePar:              option.RequirePar,
+		RedirectUris:            strings.Join(option.RedirectUris, " "),
+		AllowLoopbackPort:       option.AllowLoopbackPort,
+		TokenEndpointAuthMethod: option.TokenEndpointAuthMethod,
+		AllowedScopes:           strings.Join(option.AllowedScopes, " "),
+		ClientCredentialsScopes: strings.Join(option.ClientCredentialsScopes, " "),
+		FirstParty:              option.FirstParty,
+		PostLogoutRedirectUris:  strings.Join(option.PostLogoutRedirectUris, " "),
+		FrontchannelLogoutUri:   option.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    option.BackchannelLogoutUri,
+		TokenExchangeAudiences:  strings.Join(option.TokenExchangeAudiences, " "),
+		Jwks:                    option.Jwks,
+		JwksUri:                 option.JwksUri,
+		GrantTypes:              strings.Join(option.GrantTypes, " "),
+		ResponseTypes:           strings.Join(option.ResponseTypes, " "),
+	}
+	err = checkClientKeys(&app)
+	if err != nil {
+		return nil, err
+	}
+	err = checkParRequirement(&app)
+	if err != nil {
+		return nil, err
 	}
 	claims := &jwt.StandardClaims{
 		Id:        app.AppId,
 		ExpiresAt: time.Now().Add(time.Duration(config.Instance.JWTConfig.AppTokenExpire) * time.Second).Unix(),
-		Issuer:    config.Instance.JWTConfig.Issuer,
+		Issuer:    config.Instance.JWTConfig.GetIssuer(),
 		IssuedAt:  time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString([]byte(config.Instance.JWTConfig.Secret))
+	ss, err := signToken(claims)
 	if err != nil {
 		return nil, err
 	}
 	app.Secret = ss
 
-	err = database.Instance.Create(&app).Error
+	err = tx.Create(&app).Error
 	if err != nil {
 		return nil, err
 	}
@@ -57,142 +166,336 @@ func GetAppWithAppId(appId string) (*database.App, error) {
 	return &app, nil
 }
 
-func LoginWithApp(appId string, username string, password string) (*database.User, string, error) {
-	app := database.App{
-		AppId: appId,
-	}
-	err := database.Instance.Where("app_id = ?", appId).First(&app).Error
-	if err != nil {
-		return nil, "", err
-	}
+// AuthCodeOption parameters of the authorization request which are saved with the auth code
+type AuthCodeOption struct {
+	RedirectUri         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// AuthTime time the user entered the password, defaults to now
+	AuthTime *time.Time
+	// Sid SSO session the code is issued in, empty for codes issued without a browser session
+	Sid string
+	// State state of the authorization request, not saved with the code but carried through the consent page
+	State string
+}
 
-	user := &database.User{Username: username}
-	err = database.Instance.Where("username = ?", username).First(user).Error
+// AppToken tokens issued to app
+type AppToken struct {
+	AccessToken     string
+	RefreshToken    string
+	IdToken         string
+	Scope           string
+	IssuedTokenType string
+	// ExpiresIn lifetime of the access token in seconds when it differs from the configured one
+	ExpiresIn int64
+}
+
+// CheckUserPassword authenticate the user with username and password
+func CheckUserPassword(username string, password string) (*database.User, error) {
+	user := &database.User{}
+	err := database.Instance.Where("username = ?", username).First(user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return nil, "", InvalidateUsernameOrPassword
+			return nil, InvalidateUsernameOrPassword
 		}
-		return nil, "", err
+		return nil, err
 	}
 	encryptionErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if encryptionErr != nil {
-		return nil, "", InvalidateUsernameOrPassword
+		return nil, InvalidateUsernameOrPassword
 	}
-	authId, err := GenerateAuthCode(user.ID, app.ID)
+	return user, nil
+}
+func LoginWithUser(userId uint, appId string, option AuthCodeOption) (string, *database.AuthorizationRequest, error) {
+	app, err := GetAppByAppId(appId)
 	if err != nil {
-		return nil, "", err
+		return "", nil, err
 	}
-	return user, authId, nil
+	return AuthorizeApp(userId, app, option)
 }
-func LoginWithUser(userId uint, appId string) (string, error) {
-	app := database.App{
-		AppId: appId,
+func GenerateAuthCode(userId uint, app *database.App, option AuthCodeOption) (string, error) {
+	err := checkAuthCodeOption(app, &option)
+	if err != nil {
+		return "", err
 	}
-	err := database.Instance.Where("app_id = ?", appId).First(&app).Error
+	authId, err := newRequestId()
 	if err != nil {
 		return "", err
 	}
-	return GenerateAuthCode(userId, app.ID)
-}
-func GenerateAuthCode(userId uint, appId uint) (string, error) {
-	authId := xid.New().String()
-	authCode := database.AuthorizationCode{
-		Code:   authId,
-		AppId:  &appId,
-		UserId: &userId,
+	authTime := time.Now()
+	if option.AuthTime != nil {
+		authTime = *option.AuthTime
 	}
-	err := database.Instance.Create(&authCode).Error
+	authCode := database.AuthorizationCode{
+		Code:                authId,
+		AppId:               &app.ID,
+		UserId:              &userId,
+		RedirectUri:         option.RedirectUri,
+		Scope:               option.Scope,
+		Nonce:               option.Nonce,
+		AuthTime:            &authTime,
+		CodeChallenge:       option.CodeChallenge,
+		CodeChallengeMethod: option.CodeChallengeMethod,
+		Sid:                 option.Sid,
+	}
+	err = database.Instance.Create(&authCode).Error
 	if err != nil {
 		return "", err
 	}
+	if option.Sid != "" {
+		err = addSessionApp(option.Sid, app.ID)
+		if err != nil {
+			return "", err
+		}
+	}
 	return authId, nil
 }
 
+// checkAuthCodeOption validate the authorization request of the app, the scope is normalized
+func checkAuthCodeOption(app *database.App, option *AuthCodeOption) error {
+	if app.ResponseTypes != "" && !HasScope(app.ResponseTypes, "code") {
+		return UnauthorizedClient
+	}
+	err := checkCodeChallenge(app, option)
+	if err != nil {
+		return err
+	}
+	option.Scope, err = ResolveScope(app, option.Scope)
+	return err
+}
+
 // GenerateAppTokenByPassword for login with username and password with appid
-func GenerateAppTokenByPassword(appId string, username string, password string) (string, string, error) {
+// jkt is the thumbprint of the DPoP key the tokens are bound to, empty for bearer tokens
+func GenerateAppTokenByPassword(appId string, username string, password string, scope string, jkt string) (*AppToken, error) {
 	app, err := GetAppByAppId(appId)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	scope, err = ResolveScope(app, scope)
+	if err != nil {
+		return nil, err
 	}
 	user := &database.User{Username: username}
 	err = database.Instance.Where("username = ?", username).First(user).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			return "", "", InvalidateUsernameOrPassword
+			return nil, InvalidateUsernameOrPassword
 		}
-		return "", "", err
+		return nil, err
 	}
 	encryptionErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if encryptionErr != nil {
-		return "", "", InvalidateUsernameOrPassword
+		return nil, InvalidateUsernameOrPassword
 	}
-	_, accessTokenString, err := newJWTClaimsAndTokenString("access", username, "self")
+	accessClaims, accessTokenString, err := newJWTClaimsAndTokenString("access", username, app.AppId, app.AppId, scope, jkt)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	_, refreshTokenString, err := newJWTClaimsAndTokenString("refresh", user.Username, app.AppId)
+	familyId := newTokenFamily()
+	err = saveIssuedToken(accessClaims, &user.ID, &app.ID, nil, familyId)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	appToken := &AppToken{AccessToken: accessTokenString, Scope: scope}
+	if canIssueRefreshToken(app, scope, jkt) {
+		var refreshClaims *AuthClaim
+		refreshClaims, appToken.RefreshToken, err = newJWTClaimsAndTokenString("refresh", user.Username, app.AppId, app.AppId, scope, refreshTokenJkt(app, jkt))
+		if err != nil {
+			return nil, err
+		}
+		err = saveIssuedToken(refreshClaims, &user.ID, &app.ID, nil, familyId)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return accessTokenString, refreshTokenString, nil
+	return appToken, nil
 }
-func GenerateAppToken(authCode string) (string, string, error) {
-	authRecord := &database.AuthorizationCode{
-		Code: authCode,
+
+// AuthCodeGrantOption parameters of the authorization_code grant
+type AuthCodeGrantOption struct {
+	Code         string
+	ClientId     string
+	CodeVerifier string
+	RedirectUri  string
+	// Jkt thumbprint of the DPoP key the tokens are bound to
+	Jkt string
+}
+
+// GenerateAppToken exchange the auth code for tokens, the code can only be redeemed once by the app it was issued to
+func GenerateAppToken(option AuthCodeGrantOption) (*AppToken, error) {
+	authRecord := &database.AuthorizationCode{}
+	err := database.Instance.Unscoped().Where("code = ?", option.Code).First(authRecord).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InvalidateAuthCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if authRecord.DeletedAt.Valid {
+		// the code is redeemed twice, tokens issued from it may be leaked (RFC 6749 section 4.1.2)
+		err = RevokeTokensOfAuthCode(authRecord.ID)
+		if err != nil {
+			return nil, err
+		}
+		Logger.Warnf("auth code %d is reused, tokens issued from it are revoked", authRecord.ID)
+		return nil, AuthCodeReused
 	}
-	err := database.Instance.Where("code = ?", authCode).First(authRecord).Error
+	err = database.Instance.Preload("User").Preload("App").Where("id = ?", authRecord.ID).First(authRecord).Error
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	if authRecord.App == nil || authRecord.User == nil || authRecord.App.AppId != option.ClientId {
+		return nil, InvalidateAuthCode
 	}
 	isAuthCodeExpire := authRecord.CreatedAt.Add(time.Duration(config.Instance.JWTConfig.AuthCodeExpires)*time.Second).Unix() < time.Now().Unix()
 	if isAuthCodeExpire {
-		return "", "", AuthCodeExpire
+		return nil, AuthCodeExpire
+	}
+	if authRecord.RedirectUri == "" {
+		// code issued without redirect, e.g. by /oauth/authcode
+		if option.RedirectUri != "" {
+			if _, err = ResolveRedirectUri(authRecord.App, option.RedirectUri); err != nil {
+				return nil, err
+			}
+		}
+	} else if option.RedirectUri != authRecord.RedirectUri {
+		// redirect_uri may only be omitted when the app has a single registered redirect uri
+		if option.RedirectUri != "" || len(GetRedirectUris(authRecord.App)) != 1 {
+			return nil, InvalidateAuthCode
+		}
 	}
-	err = database.Instance.Preload("User").Preload("App").Where("code = ?", authCode).First(authRecord).Error
+	err = verifyCodeVerifier(authRecord, option.CodeVerifier)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	_, accessTokenString, err := newJWTClaimsAndTokenString("access", authRecord.User.Username, authRecord.App.AppId)
-	if err != nil {
-		return "", "", err
+	result := database.Instance.Delete(authRecord)
+	if result.Error != nil {
+		return nil, result.Error
 	}
+	if result.RowsAffected == 0 {
+		// redeemed by a concurrent request
+		return nil, AuthCodeReused
+	}
+	return issueUserToken(authRecord.User, authRecord.App, authRecord, option.Jkt)
+}
 
-	_, refreshTokenString, err := newJWTClaimsAndTokenString("refresh", authRecord.User.Username, authRecord.App.AppId)
+// issueUserToken issue tokens of a new family to the app on behalf of the user.
+// grant carries the scope and the authentication state, its ID links the tokens to the auth code when set.
+// The tokens are bound to the DPoP key of jkt when set
+func issueUserToken(user *database.User, app *database.App, grant *database.AuthorizationCode, jkt string) (*AppToken, error) {
+	var authCodeId *uint
+	if grant.ID != 0 {
+		authCodeId = &grant.ID
+	}
+	accessClaims, accessTokenString, err := newJWTClaimsAndTokenString("access", user.Username, app.AppId, app.AppId, grant.Scope, jkt)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-
-	// delete auth code
-	//err = database.Instance.Unscoped().Delete(authRecord).Error
-	return accessTokenString, refreshTokenString, nil
+	familyId := newTokenFamily()
+	err = saveIssuedToken(accessClaims, &user.ID, &app.ID, authCodeId, familyId)
+	if err != nil {
+		return nil, err
+	}
+	appToken := &AppToken{AccessToken: accessTokenString, Scope: grant.Scope}
+	if canIssueRefreshToken(app, grant.Scope, jkt) {
+		var refreshClaims *AuthClaim
+		refreshClaims, appToken.RefreshToken, err = newJWTClaimsAndTokenString("refresh", user.Username, app.AppId, app.AppId, grant.Scope, refreshTokenJkt(app, jkt))
+		if err != nil {
+			return nil, err
+		}
+		err = saveIssuedToken(refreshClaims, &user.ID, &app.ID, authCodeId, familyId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if HasScope(grant.Scope, ScopeOpenId) {
+		appToken.IdToken, err = newIdToken(user, app, grant, accessTokenString)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return appToken, nil
 }
 
-func RefreshToken(refreshToken string) (string, string, error) {
-	refreshUserAuth, err := ParseToken(refreshToken)
+// RefreshToken exchange the refresh token for a new token pair, the refresh token is rotated and can not be used again.
+// app is the authenticated client, the token must have been issued to it.
+// scope may narrow the scope of the new access token, the refresh token keeps the original scope.
+// jkt is the thumbprint of the DPoP proof of the request, required for refresh tokens of public clients
+func RefreshToken(refreshToken string, app *database.App, scope string, jkt string) (*AppToken, error) {
+	refreshUserAuth, err := parseTokenClaims(refreshToken)
+	if err != nil {
+		if _, ok := err.(*jwt.ValidationError); ok {
+			return nil, InvalidateTokenType
+		}
+		return nil, err
+	}
+	if refreshUserAuth.Type != "refresh" {
+		return nil, InvalidateTokenType
+	}
+	scope, err = narrowScope(refreshUserAuth.Scope, scope)
+	if err != nil {
+		return nil, err
+	}
+	clientId := refreshUserAuth.GetClientId()
+	if app == nil || clientId != app.AppId {
+		return nil, TokenNotOwnedByClient
+	}
+	// the proof must be made with the key the refresh token is bound to
+	if boundJkt := getTokenJkt(refreshUserAuth); boundJkt != "" && boundJkt != jkt {
+		return nil, DPoPKeyMismatch
+	}
+	// the app and the user may be removed after the token was issued
+	refreshJkt := ""
+	if clientId != "" {
+		tokenApp, err := GetAppByAppId(clientId)
+		if err != nil {
+			return nil, InvalidateAppError
+		}
+		if IsPublicClient(tokenApp) && jkt == "" {
+			return nil, DPoPProofRequired
+		}
+		refreshJkt = refreshTokenJkt(tokenApp, jkt)
+	}
+	if username := refreshUserAuth.GetUsername(); username != "" {
+		err = checkTokenOfUser(refreshUserAuth, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+	issued, err := rotateRefreshToken(refreshUserAuth)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	// check app is valid
-	_, accessTokenString, err := newJWTClaimsAndTokenString("access", refreshUserAuth.Id, refreshUserAuth.Subject)
+	accessClaims, accessTokenString, err := newJWTClaimsAndTokenString("access", refreshUserAuth.GetUsername(), refreshUserAuth.Subject, clientId, scope, jkt)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	_, refreshTokenString, err := newJWTClaimsAndTokenString("refresh", refreshUserAuth.Id, refreshUserAuth.Subject)
+	refreshClaims, refreshTokenString, err := newJWTClaimsAndTokenString("refresh", refreshUserAuth.GetUsername(), refreshUserAuth.Subject, clientId, refreshUserAuth.Scope, refreshJkt)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	return accessTokenString, refreshTokenString, nil
+	for _, claims := range []*AuthClaim{accessClaims, refreshClaims} {
+		err = saveIssuedToken(claims, issued.UserId, issued.AppId, issued.AuthCodeId, issued.FamilyId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &AppToken{AccessToken: accessTokenString, RefreshToken: refreshTokenString, Scope: scope}, nil
 }
-func newJWTClaimsAndTokenString(claimsType string, id string, appId string) (*AuthClaim, string, error) {
-	claims := newJWTClaims(claimsType, id, appId)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(config.Instance.JWTConfig.Secret))
+
+// newJWTClaimsAndTokenString sign a new token, the token is bound to the DPoP key of jkt when set
+func newJWTClaimsAndTokenString(claimsType string, username string, appId string, clientId string, scope string, jkt string) (*AuthClaim, string, error) {
+	claims := newJWTClaims(claimsType, username, appId, clientId, scope)
+	bindToKey(claims, jkt)
+	tokenString, err := signToken(claims)
 	if err != nil {
 		return nil, "", err
 	}
 	return claims, tokenString, nil
 }
-func newJWTClaims(claimsType string, id string, appId string) *AuthClaim {
+func newJWTClaims(claimsType string, username string, appId string, clientId string, scope string) *AuthClaim {
 	var expire int64
 	switch claimsType {
 	case "access":
@@ -202,13 +505,16 @@ func newJWTClaims(claimsType string, id string, appId string) *AuthClaim {
 	}
 	accessTokenClaims := &AuthClaim{
 		StandardClaims: jwt.StandardClaims{
-			Id:        id,
+			Id:        xid.New().String(),
 			ExpiresAt: expire,
-			Issuer:    config.Instance.JWTConfig.Issuer,
+			Issuer:    config.Instance.JWTConfig.GetIssuer(),
 			IssuedAt:  time.Now().Unix(),
 			Subject:   appId,
 		},
-		Type: claimsType,
+		Type:     claimsType,
+		Scope:    scope,
+		Username: username,
+		ClientId: clientId,
 	}
 	return accessTokenClaims
 }
@@ -254,6 +560,157 @@ func (b *AppQueryBuilder) GetDataAndCount() ([]*database.App, int64, error) {
 	return apps, count, nil
 }
 
+// GenerateClientToken client_credentials grant, the token represents the app itself and has no refresh token
+func GenerateClientToken(app *database.App, scope string, jkt string) (*AppToken, error) {
+	if IsPublicClient(app) {
+		return nil, UnauthorizedClient
+	}
+	allowed := strings.Fields(app.ClientCredentialsScopes)
+	if scope == "" {
+		scope = strings.Join(allowed, " ")
+	}
+	for _, item := range strings.Fields(scope) {
+		if !HasScope(app.ClientCredentialsScopes, item) {
+			return nil, InvalidateScope
+		}
+	}
+	claims, accessTokenString, err := newJWTClaimsAndTokenString("access", "", app.AppId, app.AppId, scope, jkt)
+	if err != nil {
+		return nil, err
+	}
+	err = saveIssuedToken(claims, nil, &app.ID, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return &AppToken{AccessToken: accessTokenString, Scope: scope}, nil
+}
+
+// UpdateAppOption fields of app to update, nil for unchanged
+type UpdateAppOption struct {
+	Name                    *string
+	Callback                *string
+	RedirectUris            []string
+	AllowLoopbackPort       *bool
+	RequirePkce             *bool
+	RequirePar              *bool
+	TokenEndpointAuthMethod *string
+	AllowedScopes           []string
+	ClientCredentialsScopes []string
+	FirstParty              *bool
+	PostLogoutRedirectUris  []string
+	FrontchannelLogoutUri   *string
+	BackchannelLogoutUri    *string
+	TokenExchangeAudiences  []string
+	Jwks                    *string
+	JwksUri                 *string
+	GrantTypes              []string
+	ResponseTypes           []string
+}
+
+// isAdminOnly whether the option only changes fields that are managed by admins
+func (o UpdateAppOption) isAdminOnly() bool {
+	return o.Name == nil && o.Callback == nil && o.RedirectUris == nil && o.AllowLoopbackPort == nil &&
+		o.RequirePkce == nil && o.RequirePar == nil && o.TokenEndpointAuthMethod == nil &&
+		o.AllowedScopes == nil && o.ClientCredentialsScopes == nil && o.PostLogoutRedirectUris == nil &&
+		o.FrontchannelLogoutUri == nil && o.BackchannelLogoutUri == nil && o.Jwks == nil && o.JwksUri == nil &&
+		o.GrantTypes == nil && o.ResponseTypes == nil
+}
+
+// UpdateApp update the app of the user, admins may also update the admin-only fields of apps they do not own
+func UpdateApp(appId string, userId uint, admin bool, option UpdateAppOption) (*database.App, error) {
+	app, err := GetAppByAppId(appId)
+	if err != nil {
+		return nil, err
+	}
+	isOwner := app.UserId != nil && *app.UserId == userId
+	if !isOwner && !(admin && option.isAdminOnly()) {
+		return nil, InvalidateAppError
+	}
+	if option.Name != nil {
+		app.Name = *option.Name
+	}
+	if option.Callback != nil {
+		app.Callback = *option.Callback
+	}
+	if option.RequirePkce != nil {
+		app.RequirePkce = *option.RequirePkce
+	}
+	if option.RequirePar != nil {
+		app.RequirePar = *option.RequirePar
+	}
+	if option.RedirectUris != nil {
+		if err = checkRedirectUris(option.RedirectUris); err != nil {
+			return nil, err
+		}
+		app.RedirectUris = strings.Join(option.RedirectUris, " ")
+	}
+	if option.AllowLoopbackPort != nil {
+		app.AllowLoopbackPort = *option.AllowLoopbackPort
+	}
+	if option.TokenEndpointAuthMethod != nil {
+		if err = checkClientAuthMethod(*option.TokenEndpointAuthMethod); err != nil {
+			return nil, err
+		}
+		app.TokenEndpointAuthMethod = *option.TokenEndpointAuthMethod
+	}
+	if option.AllowedScopes != nil {
+		if err = checkScopes(option.AllowedScopes); err != nil {
+			return nil, err
+		}
+		app.AllowedScopes = strings.Join(option.AllowedScopes, " ")
+	}
+	if option.ClientCredentialsScopes != nil {
+		if err = checkScopes(option.ClientCredentialsScopes); err != nil {
+			return nil, err
+		}
+		app.ClientCredentialsScopes = strings.Join(option.ClientCredentialsScopes, " ")
+	}
+	if option.FirstParty != nil {
+		app.FirstParty = *option.FirstParty
+	}
+	if option.PostLogoutRedirectUris != nil {
+		app.PostLogoutRedirectUris = strings.Join(option.PostLogoutRedirectUris, " ")
+	}
+	if option.FrontchannelLogoutUri != nil {
+		app.FrontchannelLogoutUri = *option.FrontchannelLogoutUri
+	}
+	if option.BackchannelLogoutUri != nil {
+		app.BackchannelLogoutUri = *option.BackchannelLogoutUri
+	}
+	if option.TokenExchangeAudiences != nil {
+		app.TokenExchangeAudiences = strings.Join(option.TokenExchangeAudiences, " ")
+	}
+	if option.Jwks != nil {
+		app.Jwks = *option.Jwks
+	}
+	if option.JwksUri != nil {
+		app.JwksUri = *option.JwksUri
+	}
+	if option.GrantTypes != nil {
+		app.GrantTypes = strings.Join(option.GrantTypes, " ")
+	}
+	if option.ResponseTypes != nil {
+		app.ResponseTypes = strings.Join(option.ResponseTypes, " ")
+	}
+	err = checkLogoutUris(strings.Fields(app.PostLogoutRedirectUris), app.FrontchannelLogoutUri, app.BackchannelLogoutUri)
+	if err != nil {
+		return nil, err
+	}
+	err = checkClientKeys(app)
+	if err != nil {
+		return nil, err
+	}
+	err = checkParRequirement(app)
+	if err != nil {
+		return nil, err
+	}
+	err = database.Instance.Save(app).Error
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
 func RemoveAppByAppId(appId string, userId uint) error {
 	// find app
 	app := &database.App{}
@@ -261,7 +718,7 @@ func RemoveAppByAppId(appId string, userId uint) error {
 	if err != nil {
 		return err
 	}
-	if *app.UserId != userId {
+	if app.UserId == nil || *app.UserId != userId {
 		return InvalidateAppError
 	}
 	err = database.Instance.Unscoped().Delete(&database.App{}, "app_id = ?", appId).Error
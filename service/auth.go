@@ -3,8 +3,10 @@ package service
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/dgrijalva/jwt-go"
-	"github.com/projectxpolaris/youauth/config"
 	"github.com/projectxpolaris/youauth/database"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -14,12 +16,14 @@ var InvalidateUsernameOrPassword = errors.New("invalid username or password")
 var InvalidateTokenType = errors.New("invalid token type")
 var TokenExpired = errors.New("token expired")
 
-func CreateUser(Username string, Password string) (*database.User, error) {
+func CreateUser(Username string, Password string, Email string) (*database.User, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
-	user := &database.User{Username: Username, Password: string(hashedPassword)}
+	now := time.Now()
+	// tokens of a deleted user with the same username must not be accepted for the new user
+	user := &database.User{Username: Username, Password: string(hashedPassword), Email: Email, TokensValidAfter: &now}
 
 	err = database.Instance.Create(user).Error
 	if err != nil {
@@ -43,7 +47,11 @@ func GenerateSelfToken(username string, password string) (string, *database.User
 	if encryptionErr != nil {
 		return "", nil, InvalidateUsernameOrPassword
 	}
-	_, accessTokenString, err := newJWTClaimsAndTokenString("access", username, "self")
+	claims, accessTokenString, err := newJWTClaimsAndTokenString("access", username, "self", "", "", "")
+	if err != nil {
+		return "", nil, err
+	}
+	err = saveIssuedToken(claims, &user.ID, nil, nil, "")
 	if err != nil {
 		return "", nil, err
 	}
@@ -52,12 +60,55 @@ func GenerateSelfToken(username string, password string) (string, *database.User
 }
 
 func ParseToken(tokenString string) (*AuthClaim, error) {
+	claims, err := parseTokenClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := isTokenRevoked(claims.trackingId())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, TokenRevoked
+	}
+	if username := claims.GetUsername(); username != "" {
+		err = checkTokenOfUser(claims, username)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// checkTokenOfUser reject tokens of deleted users and tokens issued before the credential of the user changed,
+// tokens issued before tokens were tracked can only be revoked in this way
+func checkTokenOfUser(claims *AuthClaim, username string) error {
+	user, err := GetUserByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return TokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if user.TokensValidAfter != nil && claims.IssuedAt < user.TokensValidAfter.Unix() {
+		return TokenRevoked
+	}
+	return nil
+}
+
+// parseTokenClaims verify the signature and expiry of the token without checking the revocation state
+func parseTokenClaims(tokenString string) (*AuthClaim, error) {
 	claims := AuthClaim{}
 	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		keyPair, err := GetVerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != keyPair.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return []byte(config.Instance.JWTConfig.Secret), nil
+		return keyPair.VerifyKey, nil
 	})
 	if err != nil {
 		if jwtErr, ok := err.(*jwt.ValidationError); ok {
@@ -70,21 +121,56 @@ func ParseToken(tokenString string) (*AuthClaim, error) {
 	if !token.Valid {
 		return nil, InvalidateTokenType
 	}
+	if claims.Username == "" && claims.ClientId == "" {
+		claims.legacyHash = hashOpaqueToken(tokenString)
+	}
 	return &claims, nil
 }
+
+// IsSelfAccessToken access token issued to youauth itself by the login page, only these tokens manage the account
+func IsSelfAccessToken(claims *AuthClaim) bool {
+	return claims.Type == "access" && claims.Subject == "self" && claims.ClientId == ""
+}
+
 func GetCurrentUser(accessToken string) (*database.User, error) {
 	authClaim, err := ParseToken(accessToken)
 	if err != nil {
 		return nil, err
 	}
 	// check app is valid
-	if authClaim.Subject != "self" {
+	if !IsSelfAccessToken(authClaim) {
 		return nil, InvalidateAppError
 	}
 	user := &database.User{}
-	err = database.Instance.Where("username = ?", authClaim.Id).First(user).Error
+	err = database.Instance.Where("username = ?", authClaim.GetUsername()).First(user).Error
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
+
+// GetUserInfo user and granted scope of the access token, tokens issued to youauth itself are granted all user claims.
+// jkt is the thumbprint of the DPoP proof sent with the token, a token bound to a key is only accepted with its proof
+func GetUserInfo(accessToken string, jkt string) (*database.User, string, error) {
+	authClaim, err := ParseToken(accessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	if authClaim.Type != "access" {
+		return nil, "", InvalidateTokenType
+	}
+	if getTokenJkt(authClaim) != jkt && getTokenJkt(authClaim) != "" {
+		return nil, "", DPoPKeyMismatch
+	}
+	scope := authClaim.Scope
+	if IsSelfAccessToken(authClaim) {
+		scope = strings.Join([]string{ScopeOpenId, ScopeProfile, ScopeEmail}, " ")
+	} else if _, err = GetAppByAppId(authClaim.GetClientId()); err != nil {
+		return nil, "", InvalidateAppError
+	}
+	user, err := GetUserByUsername(authClaim.GetUsername())
+	if err != nil {
+		return nil, "", err
+	}
+	return user, scope, nil
+}
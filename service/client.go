@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/projectxpolaris/youauth/database"
+)
+
+const (
+	ClientAuthMethodBasic = "client_secret_basic"
+	ClientAuthMethodPost  = "client_secret_post"
+	ClientAuthMethodNone  = "none"
+	// ClientAuthMethodPrivateKeyJwt the client signs an assertion with a key of its registered JWKS (RFC 7523)
+	ClientAuthMethodPrivateKeyJwt = "private_key_jwt"
+)
+
+var (
+	InvalidateClient           = errors.New("invalid client")
+	InvalidateClientAuthMethod = errors.New("invalid token endpoint auth method")
+)
+
+// ClientCredential credential presented by the client, Method is the way the credential is sent
+type ClientCredential struct {
+	ClientId        string
+	ClientSecret    string
+	ClientAssertion string
+	Method          string
+}
+
+// IsPublicClient public clients can not keep a secret and are identified by client_id only
+func IsPublicClient(app *database.App) bool {
+	return app.TokenEndpointAuthMethod == ClientAuthMethodNone
+}
+
+// CheckGrantType the app may only use the grant types it registered. Token exchange is not registrable,
+// it is allowed by the audiences admins set on the app
+func CheckGrantType(app *database.App, grantType string) error {
+	if app.GrantTypes == "" || grantType == TokenExchangeGrantType || HasScope(app.GrantTypes, grantType) {
+		return nil
+	}
+	return UnauthorizedClient
+}
+
+// AuthenticateClient authenticate the client (RFC 6749 section 2.3), confidential clients must present their secret
+func AuthenticateClient(credential ClientCredential) (*database.App, error) {
+	if credential.Method == ClientAuthMethodPrivateKeyJwt {
+		return authenticateClientAssertion(credential)
+	}
+	if credential.ClientId == "" {
+		return nil, InvalidateClient
+	}
+	app, err := GetAppByAppId(credential.ClientId)
+	if err != nil {
+		return nil, InvalidateClient
+	}
+	if IsPublicClient(app) {
+		if credential.Method != ClientAuthMethodNone {
+			return nil, InvalidateClient
+		}
+		return app, nil
+	}
+	// apps using private_key_jwt have no usable shared secret
+	if app.TokenEndpointAuthMethod == ClientAuthMethodPrivateKeyJwt {
+		return nil, InvalidateClient
+	}
+	if credential.Method != ClientAuthMethodBasic && credential.Method != ClientAuthMethodPost {
+		return nil, InvalidateClient
+	}
+	if subtle.ConstantTimeCompare([]byte(credential.ClientSecret), []byte(app.Secret)) != 1 {
+		return nil, InvalidateClient
+	}
+	return app, nil
+}
+
+func checkClientAuthMethod(method string) error {
+	switch method {
+	case "", ClientAuthMethodBasic, ClientAuthMethodPost, ClientAuthMethodNone, ClientAuthMethodPrivateKeyJwt:
+		return nil
+	}
+	return InvalidateClientAuthMethod
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/util"
+)
+
+const (
+	ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	// maxClientAssertionLifetime used jti are kept until the assertion or request object expires, so long lived ones are refused
+	maxClientAssertionLifetime = time.Hour
+	maxJtiLength               = 128
+	// clientJwksCacheTime keys fetched from the jwks_uri of the app are reused for this time
+	clientJwksCacheTime = 5 * time.Minute
+	// clientJwksRefreshInterval minimal time before the jwks_uri is fetched again for an unknown kid
+	clientJwksRefreshInterval = 30 * time.Second
+	maxJwksSize               = 1 << 20
+)
+
+var (
+	InvalidateClientJwks    = errors.New("invalid client jwks")
+	InvalidateRequestObject = errors.New("invalid request object")
+	ClientAssertionReused   = errors.New("client assertion has been used before")
+)
+
+var clientJwksClient = &http.Client{Timeout: 10 * time.Second}
+
+type cachedClientJwks struct {
+	set       *util.JWKSet
+	fetchedAt time.Time
+}
+
+// clientJwksFetch fetch of a jwks_uri in progress, concurrent requests for the same uri wait for it
+type clientJwksFetch struct {
+	done chan struct{}
+	set  *util.JWKSet
+	err  error
+}
+
+var (
+	clientJwksLock    sync.Mutex
+	clientJwksCache   = map[string]*cachedClientJwks{}
+	clientJwksFetches = map[string]*clientJwksFetch{}
+)
+
+// requestObjectReservedClaims JWT claims of a request object that are not authorization parameters
+var requestObjectReservedClaims = []string{"iss", "aud", "exp", "nbf", "iat", "jti", "request", "request_uri"}
+
+// checkClientKeys the keys of a private_key_jwt app are registered either inline or by an https jwks_uri
+func checkClientKeys(app *database.App) error {
+	if app.Jwks != "" && app.JwksUri != "" {
+		return InvalidateClientJwks
+	}
+	if app.Jwks != "" {
+		set, err := util.ParseJWKSet([]byte(app.Jwks))
+		if err != nil || len(set.Keys) == 0 {
+			return InvalidateClientJwks
+		}
+	}
+	if app.JwksUri != "" {
+		u, err := url.Parse(app.JwksUri)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return InvalidateClientJwks
+		}
+	}
+	if app.TokenEndpointAuthMethod == ClientAuthMethodPrivateKeyJwt && app.Jwks == "" && app.JwksUri == "" {
+		return InvalidateClientJwks
+	}
+	return nil
+}
+
+func fetchClientJwks(jwksUri string) (*util.JWKSet, error) {
+	resp, err := clientJwksClient.Get(jwksUri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, InvalidateClientJwks
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxJwksSize))
+	if err != nil {
+		return nil, err
+	}
+	return util.ParseJWKSet(raw)
+}
+
+// getClientJwks keys registered by the app, refresh fetches the jwks_uri again unless it was fetched just now.
+// The lock is not held while fetching, so a slow jwks_uri only delays the clients using it
+func getClientJwks(app *database.App, refresh bool) (*util.JWKSet, error) {
+	if app.Jwks != "" {
+		return util.ParseJWKSet([]byte(app.Jwks))
+	}
+	if app.JwksUri == "" {
+		return nil, InvalidateClientJwks
+	}
+	clientJwksLock.Lock()
+	cached := clientJwksCache[app.JwksUri]
+	if cached != nil {
+		age := time.Since(cached.fetchedAt)
+		if age < clientJwksCacheTime && (!refresh || age < clientJwksRefreshInterval) {
+			clientJwksLock.Unlock()
+			return cached.set, nil
+		}
+	}
+	fetch := clientJwksFetches[app.JwksUri]
+	if fetch != nil {
+		clientJwksLock.Unlock()
+		<-fetch.done
+		return fetch.set, fetch.err
+	}
+	fetch = &clientJwksFetch{done: make(chan struct{})}
+	clientJwksFetches[app.JwksUri] = fetch
+	clientJwksLock.Unlock()
+
+	fetch.set, fetch.err = fetchClientJwks(app.JwksUri)
+	clientJwksLock.Lock()
+	delete(clientJwksFetches, app.JwksUri)
+	if fetch.err == nil {
+		clientJwksCache[app.JwksUri] = &cachedClientJwks{set: fetch.set, fetchedAt: time.Now()}
+	}
+	clientJwksLock.Unlock()
+	close(fetch.done)
+	return fetch.set, fetch.err
+}
+
+// keyMatchesAlgorithm whether the JWK can verify signatures of the algorithm
+func keyMatchesAlgorithm(key *util.JWK, method jwt.SigningMethod) bool {
+	if key.Alg != "" && key.Alg != method.Alg() {
+		return false
+	}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return key.Kty == "RSA"
+	case *jwt.SigningMethodECDSA:
+		return key.Kty == "EC"
+	case *util.SigningMethodEd25519:
+		return key.Kty == "OKP"
+	}
+	return false
+}
+
+func findClientKey(set *util.JWKSet, kid string, method jwt.SigningMethod) *util.JWK {
+	for _, key := range set.Find(kid) {
+		if keyMatchesAlgorithm(key, method) {
+			return key
+		}
+	}
+	return nil
+}
+
+// verifyClientJwt verify a JWT signed with one of the keys registered by the app, shared secret algorithms are refused
+func verifyClientJwt(app *database.App, tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		set, err := getClientJwks(app, false)
+		if err != nil {
+			return nil, err
+		}
+		key := findClientKey(set, kid, token.Method)
+		if key == nil && kid != "" {
+			// the client may have rotated its keys since they were fetched
+			if set, err = getClientJwks(app, true); err != nil {
+				return nil, err
+			}
+			key = findClientKey(set, kid, token.Method)
+		}
+		if key == nil {
+			return nil, InvalidateClientJwks
+		}
+		return key.PublicKey()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// claimAudiences aud claim of the JWT, which is either a string or an array of strings
+func claimAudiences(claims jwt.MapClaims) []string {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		audiences := make([]string, 0)
+		for _, item := range aud {
+			if value, ok := item.(string); ok {
+				audiences = append(audiences, value)
+			}
+		}
+		return audiences
+	}
+	return nil
+}
+
+// isAudienceOfServer whether the JWT is addressed to us, by issuer or by token endpoint
+func isAudienceOfServer(claims jwt.MapClaims) bool {
+	accepted := []string{config.Instance.JWTConfig.GetIssuer(), config.Instance.JWTConfig.GetBaseUrl() + "/token"}
+	for _, audience := range claimAudiences(claims) {
+		for _, item := range accepted {
+			if audience == item {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticateClientAssertion private_key_jwt client authentication (RFC 7523 section 3)
+func authenticateClientAssertion(credential ClientCredential) (*database.App, error) {
+	clientId := credential.ClientId
+	if clientId == "" {
+		// client_id is optional, the client is the subject of the assertion
+		unverified := jwt.MapClaims{}
+		_, _, err := new(jwt.Parser).ParseUnverified(credential.ClientAssertion, unverified)
+		if err != nil {
+			return nil, InvalidateClient
+		}
+		clientId, _ = unverified["sub"].(string)
+	}
+	app, err := GetAppByAppId(clientId)
+	if err != nil || app.TokenEndpointAuthMethod != ClientAuthMethodPrivateKeyJwt {
+		return nil, InvalidateClient
+	}
+	claims, err := verifyClientJwt(app, credential.ClientAssertion)
+	if err != nil {
+		return nil, InvalidateClient
+	}
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if issuer != app.AppId || subject != app.AppId || !isAudienceOfServer(claims) {
+		return nil, InvalidateClient
+	}
+	jti, expiresAt, ok := getClientJwtReplayClaims(claims)
+	if !ok {
+		return nil, InvalidateClient
+	}
+	err = useClientAssertion(app, jti, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
+}
+
+// getClientJwtReplayClaims jti and expiry of a JWT signed by the client, both are required and the lifetime is bounded
+func getClientJwtReplayClaims(claims jwt.MapClaims) (string, time.Time, bool) {
+	exp, ok := claims["exp"].(float64)
+	expiresAt := time.Unix(int64(exp), 0)
+	if !ok || expiresAt.After(time.Now().Add(maxClientAssertionLifetime)) {
+		return "", expiresAt, false
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" || len(jti) > maxJtiLength {
+		return "", expiresAt, false
+	}
+	return jti, expiresAt, true
+}
+
+// useClientAssertion remember the jti until the assertion expires, an assertion or request object is only accepted once
+func useClientAssertion(app *database.App, jti string, expiresAt time.Time) error {
+	err := database.Instance.Unscoped().Where("expires_at < ?", time.Now()).Delete(&database.UsedClientAssertion{}).Error
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = database.Instance.Model(&database.UsedClientAssertion{}).Where("app_id = ? AND jti = ?", app.ID, jti).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		Logger.WithField("client_id", app.AppId).Warn("client assertion replayed")
+		return ClientAssertionReused
+	}
+	return database.Instance.Create(&database.UsedClientAssertion{AppId: app.ID, Jti: jti, ExpiresAt: expiresAt}).Error
+}
+
+// ParseRequestObject verify a request object signed by the app (RFC 9101), its claims replace the authorization parameters.
+// Request objects must expire and are only accepted once
+func ParseRequestObject(app *database.App, request string) (url.Values, error) {
+	claims, err := verifyClientJwt(app, request)
+	if err != nil {
+		return nil, InvalidateRequestObject
+	}
+	issuer, _ := claims["iss"].(string)
+	if issuer != app.AppId || !isAudienceOfServer(claims) {
+		return nil, InvalidateRequestObject
+	}
+	if clientId, ok := claims["client_id"]; ok && clientId != app.AppId {
+		return nil, InvalidateRequestObject
+	}
+	jti, expiresAt, ok := getClientJwtReplayClaims(claims)
+	if !ok {
+		return nil, InvalidateRequestObject
+	}
+	err = useClientAssertion(app, jti, expiresAt)
+	if err == ClientAssertionReused {
+		return nil, InvalidateRequestObject
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range requestObjectReservedClaims {
+		delete(claims, name)
+	}
+	params := url.Values{}
+	for name, value := range claims {
+		switch v := value.(type) {
+		case string:
+			params.Set(name, v)
+		case float64:
+			params.Set(name, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			params.Set(name, strconv.FormatBool(v))
+		default:
+			raw, err := json.Marshal(v)
+			if err != nil {
+				return nil, InvalidateRequestObject
+			}
+			params.Set(name, string(raw))
+		}
+	}
+	params.Set("client_id", app.AppId)
+	return params, nil
+}
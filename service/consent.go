@@ -0,0 +1,228 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/projectxpolaris/youauth/database"
+	"gorm.io/gorm"
+)
+
+// authorizationRequestExpire time the user has to answer the consent page
+const authorizationRequestExpire = 10 * time.Minute
+
+var (
+	AuthorizationRequestNotFound = errors.New("authorization request not found or expired")
+	AccessDenied                 = errors.New("access denied by user")
+	AuthorizationRequestMismatch = errors.New("authorization request does not belong to the session")
+)
+
+// IsConsentRequired whether the user has to approve the scope for the app, first-party apps never ask
+func IsConsentRequired(userId uint, app *database.App, scope string) (bool, error) {
+	if app.FirstParty {
+		return false, nil
+	}
+	consent := &database.Consent{}
+	err := database.Instance.Where("user_id = ? AND app_id = ?", userId, app.ID).First(consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, name := range strings.Fields(scope) {
+		if !HasScope(consent.Scope, name) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GrantConsent remember the scope granted to the app, scopes granted before are kept
+func GrantConsent(userId uint, appId uint, scope string) error {
+	consent := &database.Consent{}
+	err := database.Instance.Where("user_id = ? AND app_id = ?", userId, appId).First(consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return database.Instance.Create(&database.Consent{UserId: userId, AppId: appId, Scope: scope}).Error
+	}
+	if err != nil {
+		return err
+	}
+	granted := strings.Fields(consent.Scope)
+	for _, name := range strings.Fields(scope) {
+		if !HasScope(consent.Scope, name) {
+			granted = append(granted, name)
+		}
+	}
+	return database.Instance.Model(consent).Update("scope", strings.Join(granted, " ")).Error
+}
+
+// AuthorizeApp issue an auth code to the app, or create an authorization request when the user has to consent first
+func AuthorizeApp(userId uint, app *database.App, option AuthCodeOption) (string, *database.AuthorizationRequest, error) {
+	err := checkAuthCodeOption(app, &option)
+	if err != nil {
+		return "", nil, err
+	}
+	required, err := IsConsentRequired(userId, app, option.Scope)
+	if err != nil {
+		return "", nil, err
+	}
+	if required {
+		request, err := createAuthorizationRequest(userId, app, option)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", request, nil
+	}
+	authCode, err := GenerateAuthCode(userId, app, option)
+	if err != nil {
+		return "", nil, err
+	}
+	return authCode, nil, nil
+}
+
+func newRequestId() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func createAuthorizationRequest(userId uint, app *database.App, option AuthCodeOption) (*database.AuthorizationRequest, error) {
+	requestId, err := newRequestId()
+	if err != nil {
+		return nil, err
+	}
+	csrfToken, err := newRequestId()
+	if err != nil {
+		return nil, err
+	}
+	ticket := ""
+	if option.Sid == "" {
+		// no session to bind the request to, the consent url carries a ticket instead
+		ticket, err = newRequestId()
+		if err != nil {
+			return nil, err
+		}
+	}
+	request := &database.AuthorizationRequest{
+		RequestId:           requestId,
+		AppId:               &app.ID,
+		UserId:              &userId,
+		App:                 app,
+		RedirectUri:         option.RedirectUri,
+		Scope:               option.Scope,
+		Nonce:               option.Nonce,
+		CodeChallenge:       option.CodeChallenge,
+		CodeChallengeMethod: option.CodeChallengeMethod,
+		AuthTime:            option.AuthTime,
+		Sid:                 option.Sid,
+		State:               option.State,
+		CsrfToken:           csrfToken,
+		ExpiresAt:           time.Now().Add(authorizationRequestExpire),
+	}
+	if ticket != "" {
+		request.TicketHash = hashOpaqueToken(ticket)
+	}
+	err = database.Instance.Omit("App").Create(request).Error
+	if err != nil {
+		return nil, err
+	}
+	request.Ticket = ticket
+	return request, nil
+}
+
+// GetAuthorizationRequest pending authorization request with its app
+func GetAuthorizationRequest(requestId string) (*database.AuthorizationRequest, error) {
+	request := &database.AuthorizationRequest{}
+	err := database.Instance.Preload("App").Where("request_id = ?", requestId).First(request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, AuthorizationRequestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.App == nil || time.Now().After(request.ExpiresAt) {
+		return nil, AuthorizationRequestNotFound
+	}
+	return request, nil
+}
+
+// GetSessionAuthorizationRequest pending authorization request of the session, requests of other sessions are rejected.
+// Requests made without a session are checked against the ticket of the consent url instead
+func GetSessionAuthorizationRequest(requestId string, session *database.Session, ticket string) (*database.AuthorizationRequest, error) {
+	request, err := GetAuthorizationRequest(requestId)
+	if err != nil {
+		return nil, err
+	}
+	if !isAuthorizationRequestOwner(request, session, ticket) {
+		return nil, AuthorizationRequestMismatch
+	}
+	return request, nil
+}
+
+func isAuthorizationRequestOwner(request *database.AuthorizationRequest, session *database.Session, ticket string) bool {
+	if request.UserId == nil {
+		return false
+	}
+	if request.TicketHash != "" {
+		return subtle.ConstantTimeCompare([]byte(request.TicketHash), []byte(hashOpaqueToken(ticket))) == 1
+	}
+	return session != nil && session.UserId != nil && request.Sid != "" &&
+		*session.UserId == *request.UserId && session.Sid == request.Sid
+}
+
+// claimAuthorizationRequest the request can only be answered once, by the session or ticket holder that made it with the csrf token of the consent page
+func claimAuthorizationRequest(requestId string, session *database.Session, ticket string, csrfToken string) (*database.AuthorizationRequest, error) {
+	request, err := GetSessionAuthorizationRequest(requestId, session, ticket)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(request.CsrfToken), []byte(csrfToken)) != 1 {
+		return nil, AuthorizationRequestMismatch
+	}
+	result := database.Instance.Delete(request)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, AuthorizationRequestNotFound
+	}
+	return request, nil
+}
+
+// ApproveAuthorizationRequest remember the consent and issue the auth code
+func ApproveAuthorizationRequest(requestId string, session *database.Session, ticket string, csrfToken string) (*database.AuthorizationRequest, string, error) {
+	request, err := claimAuthorizationRequest(requestId, session, ticket, csrfToken)
+	if err != nil {
+		return nil, "", err
+	}
+	err = GrantConsent(*request.UserId, request.App.ID, request.Scope)
+	if err != nil {
+		return nil, "", err
+	}
+	authCode, err := GenerateAuthCode(*request.UserId, request.App, AuthCodeOption{
+		RedirectUri:         request.RedirectUri,
+		Scope:               request.Scope,
+		Nonce:               request.Nonce,
+		CodeChallenge:       request.CodeChallenge,
+		CodeChallengeMethod: request.CodeChallengeMethod,
+		AuthTime:            request.AuthTime,
+		Sid:                 request.Sid,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return request, authCode, nil
+}
+
+// DenyAuthorizationRequest drop the request, the app is told access_denied
+func DenyAuthorizationRequest(requestId string, session *database.Session, ticket string, csrfToken string) (*database.AuthorizationRequest, error) {
+	return claimAuthorizationRequest(requestId, session, ticket, csrfToken)
+}
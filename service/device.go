@@ -0,0 +1,173 @@
+package service
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"gorm.io/gorm"
+)
+
+// userCodeCharset consonants only, so that user codes are easy to type and never spell words (RFC 8628 section 6.1)
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// slowDownIncrease seconds added to the interval every time the device polls too fast
+const slowDownIncrease = 5
+
+var (
+	AuthorizationPending = errors.New("authorization pending")
+	SlowDown             = errors.New("polling too frequently, slow down")
+	DeviceCodeExpired    = errors.New("device code expired")
+	InvalidateDeviceCode = errors.New("invalid device code")
+	InvalidateUserCode   = errors.New("invalid or expired user code")
+)
+
+func newUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeCharset[n.Int64()]
+	}
+	return string(code[:4]) + "-" + string(code[4:]), nil
+}
+
+// normalizeUserCode users may type the code in lower case and without the dash
+func normalizeUserCode(userCode string) string {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(userCode))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// CreateDeviceAuthorization start the device flow for the app, the user approves it by entering the user code
+func CreateDeviceAuthorization(app *database.App, scope string) (*database.DeviceCode, error) {
+	scope, err := ResolveScope(app, scope)
+	if err != nil {
+		return nil, err
+	}
+	deviceCode, err := newRequestId()
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return nil, err
+	}
+	device := &database.DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		AppId:      &app.ID,
+		Scope:      scope,
+		State:      database.DeviceCodeStatePending,
+		Interval:   config.Instance.JWTConfig.DeviceCodeInterval,
+		ExpiresAt:  time.Now().Add(time.Duration(config.Instance.JWTConfig.DeviceCodeExpire) * time.Second),
+	}
+	err = database.Instance.Create(device).Error
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// GetPendingDeviceAuthorization device authorization waiting for the user, with its app
+func GetPendingDeviceAuthorization(userCode string) (*database.DeviceCode, error) {
+	device := &database.DeviceCode{}
+	err := database.Instance.Preload("App").
+		Where("user_code = ? AND state = ?", normalizeUserCode(userCode), database.DeviceCodeStatePending).
+		First(device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InvalidateUserCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.App == nil || time.Now().After(device.ExpiresAt) {
+		return nil, InvalidateUserCode
+	}
+	return device, nil
+}
+
+// AnswerDeviceAuthorization approve or deny the device authorization on behalf of the user
+func AnswerDeviceAuthorization(userCode string, userId uint, approve bool) error {
+	device, err := GetPendingDeviceAuthorization(userCode)
+	if err != nil {
+		return err
+	}
+	state := database.DeviceCodeStateDenied
+	if approve {
+		state = database.DeviceCodeStateApproved
+	}
+	authTime := time.Now()
+	result := database.Instance.Model(&database.DeviceCode{}).
+		Where("id = ? AND state = ?", device.ID, database.DeviceCodeStatePending).
+		Updates(map[string]interface{}{"state": state, "user_id": userId, "auth_time": &authTime})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return InvalidateUserCode
+	}
+	if approve {
+		return GrantConsent(userId, device.App.ID, device.Scope)
+	}
+	return nil
+}
+
+// GenerateDeviceToken device_code grant, polled by the device until the user answers
+func GenerateDeviceToken(app *database.App, deviceCode string, jkt string) (*AppToken, error) {
+	device := &database.DeviceCode{}
+	err := database.Instance.Preload("App").Preload("User").Where("device_code = ?", deviceCode).First(device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InvalidateDeviceCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if device.App == nil || device.App.AppId != app.AppId {
+		return nil, InvalidateDeviceCode
+	}
+	now := time.Now()
+	if now.After(device.ExpiresAt) {
+		return nil, DeviceCodeExpired
+	}
+	lastPolledAt := device.LastPolledAt
+	updates := map[string]interface{}{"last_polled_at": &now}
+	tooFast := lastPolledAt != nil && now.Sub(*lastPolledAt) < time.Duration(device.Interval)*time.Second
+	if tooFast {
+		updates["interval"] = device.Interval + slowDownIncrease
+	}
+	err = database.Instance.Model(device).Updates(updates).Error
+	if err != nil {
+		return nil, err
+	}
+	if tooFast {
+		return nil, SlowDown
+	}
+	switch device.State {
+	case database.DeviceCodeStatePending:
+		return nil, AuthorizationPending
+	case database.DeviceCodeStateDenied:
+		database.Instance.Delete(device)
+		return nil, AccessDenied
+	}
+	if device.User == nil {
+		return nil, InvalidateDeviceCode
+	}
+	// the device code can only be exchanged once
+	result := database.Instance.Delete(device)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, InvalidateDeviceCode
+	}
+	return issueUserToken(device.User, device.App, &database.AuthorizationCode{Scope: device.Scope, AuthTime: device.AuthTime}, jkt)
+}
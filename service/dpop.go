@@ -0,0 +1,96 @@
+package service
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/dpop"
+)
+
+// dpopNonceWindow a nonce handed out to a client stays valid for one to two windows
+const dpopNonceWindow = 5 * time.Minute
+
+var (
+	DPoPProofRequired = errors.New("dpop proof required")
+	DPoPKeyMismatch   = errors.New("dpop key does not match the key the token is bound to")
+)
+
+// ConfirmationClaim cnf claim binding the token to the thumbprint of a DPoP key (RFC 9449 section 6.1)
+type ConfirmationClaim struct {
+	Jkt string `json:"jkt"`
+}
+
+var (
+	dpopNoncesOnce sync.Once
+	dpopNonces     *dpop.NonceSource
+	dpopReplay     = dpop.NewJtiCache()
+)
+
+// getDPoPNonces nonces are derived from token.secret so that all instances accept them, random without a secret
+func getDPoPNonces() *dpop.NonceSource {
+	dpopNoncesOnce.Do(func() {
+		var key []byte
+		if secret := config.Instance.JWTConfig.Secret; secret != "" {
+			sum := sha256.Sum256([]byte("dpop-nonce:" + secret))
+			key = sum[:]
+		}
+		dpopNonces = dpop.NewNonceSource(key, dpopNonceWindow)
+	})
+	return dpopNonces
+}
+
+// GetDPoPNonce nonce returned in the DPoP-Nonce header
+func GetDPoPNonce() string {
+	return getDPoPNonces().Current()
+}
+
+// VerifyDPoPProof verify the proof sent to one of our endpoints, accessToken is set when the proof accompanies an access token
+func VerifyDPoPProof(proof string, method string, requestUrl string, accessToken string) (*dpop.Proof, error) {
+	return dpop.VerifyProof(proof, dpop.VerifyOption{
+		Method:       method,
+		Url:          requestUrl,
+		AccessToken:  accessToken,
+		Nonces:       getDPoPNonces(),
+		RequireNonce: config.Instance.JWTConfig.DPoPRequireNonce,
+		Replay:       dpopReplay,
+	})
+}
+
+// bindToKey bind the token to the DPoP key, unbound bearer token when jkt is empty
+func bindToKey(claims *AuthClaim, jkt string) {
+	if jkt != "" {
+		claims.Cnf = &ConfirmationClaim{Jkt: jkt}
+	}
+}
+
+// getTokenJkt thumbprint the token is bound to, empty for bearer tokens
+func getTokenJkt(claims *AuthClaim) string {
+	if claims.Cnf == nil {
+		return ""
+	}
+	return claims.Cnf.Jkt
+}
+
+// canIssueRefreshToken public clients only get refresh tokens bound to a DPoP key (RFC 9449 section 5),
+// apps which did not register the refresh_token grant never get them
+func canIssueRefreshToken(app *database.App, scope string, jkt string) bool {
+	if IsPublicClient(app) && jkt == "" {
+		return false
+	}
+	if CheckGrantType(app, "refresh_token") != nil {
+		return false
+	}
+	return isRefreshTokenAllowed(scope)
+}
+
+// refreshTokenJkt refresh tokens of confidential clients are bound to the client authentication instead of the key
+func refreshTokenJkt(app *database.App, jkt string) string {
+	if IsPublicClient(app) {
+		return jkt
+	}
+	return ""
+}
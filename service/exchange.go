@@ -0,0 +1,155 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/projectxpolaris/youauth/database"
+)
+
+const (
+	TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	AccessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	InvalidateSubjectToken        = errors.New("invalid subject token")
+	InvalidateActorToken          = errors.New("invalid actor token")
+	InvalidateTarget              = errors.New("audience is not allowed for the client")
+	UnsupportedTokenType          = errors.New("unsupported token type")
+	UnsupportedRequestedTokenType = errors.New("unsupported requested token type")
+)
+
+// ActorClaim the act claim of RFC 8693 section 4.1, nested when a delegated token is exchanged again
+type ActorClaim struct {
+	Subject  string      `json:"sub"`
+	ClientId string      `json:"client_id,omitempty"`
+	Act      *ActorClaim `json:"act,omitempty"`
+}
+
+// TokenExchangeOption parameters of the token exchange request
+type TokenExchangeOption struct {
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	RequestedTokenType string
+	Audience           string
+	Scope              string
+	// Jkt thumbprint of the DPoP key the new token is bound to, tokens bound to a key can only be exchanged with its proof
+	Jkt string
+}
+
+// checkExchangeTokenKey a token bound to a DPoP key keeps its sender constraint, the request must prove the same key
+func checkExchangeTokenKey(claims *AuthClaim, jkt string) error {
+	boundJkt := getTokenJkt(claims)
+	if boundJkt == "" {
+		return nil
+	}
+	if jkt == "" {
+		return DPoPProofRequired
+	}
+	if boundJkt != jkt {
+		return DPoPKeyMismatch
+	}
+	return nil
+}
+
+// parseExchangeToken access token presented in a token exchange request
+func parseExchangeToken(token string, tokenType string) (*AuthClaim, error) {
+	if tokenType != AccessTokenType {
+		return nil, UnsupportedTokenType
+	}
+	claims, err := ParseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != "access" {
+		return nil, InvalidateTokenType
+	}
+	return claims, nil
+}
+
+// ExchangeToken issue a down-scoped access token for the audience on behalf of the subject (RFC 8693).
+// The actor is the party of the actor token, or the client itself when no actor token is given
+func ExchangeToken(app *database.App, option TokenExchangeOption) (*AppToken, error) {
+	if IsPublicClient(app) {
+		return nil, UnauthorizedClient
+	}
+	if option.RequestedTokenType != "" && option.RequestedTokenType != AccessTokenType {
+		return nil, UnsupportedRequestedTokenType
+	}
+	if option.Audience == "" || !HasScope(app.TokenExchangeAudiences, option.Audience) {
+		return nil, InvalidateTarget
+	}
+	subject, err := parseExchangeToken(option.SubjectToken, option.SubjectTokenType)
+	if err == UnsupportedTokenType {
+		return nil, err
+	}
+	if err != nil {
+		return nil, InvalidateSubjectToken
+	}
+	err = checkExchangeTokenKey(subject, option.Jkt)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := narrowScope(subject.Scope, option.Scope)
+	if err != nil {
+		return nil, err
+	}
+	act := &ActorClaim{Subject: app.AppId, ClientId: app.AppId}
+	if option.ActorToken != "" {
+		actor, err := parseExchangeToken(option.ActorToken, option.ActorTokenType)
+		if err == UnsupportedTokenType {
+			return nil, err
+		}
+		if err != nil {
+			return nil, InvalidateActorToken
+		}
+		err = checkExchangeTokenKey(actor, option.Jkt)
+		if err != nil {
+			return nil, err
+		}
+		act = &ActorClaim{Subject: actor.Subject, ClientId: actor.GetClientId()}
+		if username := actor.GetUsername(); username != "" {
+			act.Subject = username
+		}
+	}
+	// keep the chain of delegation
+	act.Act = subject.Act
+	claims := newJWTClaims("access", subject.GetUsername(), subject.Subject, app.AppId, scope)
+	claims.Audience = option.Audience
+	claims.Act = act
+	bindToKey(claims, option.Jkt)
+	// the exchanged token never outlives the subject token
+	expiresIn := int64(0)
+	if subject.ExpiresAt < claims.ExpiresAt {
+		expiresIn = subject.ExpiresAt - claims.IssuedAt
+		claims.ExpiresAt = subject.ExpiresAt
+	}
+	tokenString, err := signToken(claims)
+	if err != nil {
+		return nil, err
+	}
+	// revoking the subject token family revokes the exchanged token as well
+	var userId *uint
+	familyId := ""
+	issued, err := getIssuedToken(subject.trackingId())
+	if err != nil {
+		return nil, err
+	}
+	if issued != nil {
+		userId = issued.UserId
+		familyId = issued.FamilyId
+	}
+	err = saveIssuedToken(claims, userId, &app.ID, nil, familyId)
+	if err != nil {
+		return nil, err
+	}
+	return &AppToken{
+		AccessToken:     tokenString,
+		Scope:           strings.Join(strings.Fields(scope), " "),
+		IssuedTokenType: AccessTokenType,
+		ExpiresIn:       expiresIn,
+	}, nil
+}
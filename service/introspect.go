@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/projectxpolaris/youauth/dpop"
+	"gorm.io/gorm"
+)
+
+// TokenIntrospection state of a token as described by RFC 7662, fields other than Active are only set for active tokens
+type TokenIntrospection struct {
+	Active    bool
+	Subject   string
+	ClientId  string
+	Scope     string
+	ExpiresAt int64
+	IssuedAt  int64
+	TokenType string
+	Username  string
+	Audience  string
+	Act       *ActorClaim
+	Cnf       *ConfirmationClaim
+}
+
+// IntrospectToken check the token is issued by us, not expired and not revoked.
+// Invalid tokens are reported as inactive instead of as an error
+func IntrospectToken(tokenString string) (*TokenIntrospection, error) {
+	inactive := &TokenIntrospection{Active: false}
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return inactive, nil
+	}
+	var tokenType string
+	switch claims.Type {
+	case "access":
+		tokenType = "Bearer"
+		if claims.Cnf != nil {
+			tokenType = dpop.TokenType
+		}
+	case "refresh":
+		tokenType = "refresh_token"
+	default:
+		// app secrets are signed by the same key but are not tokens
+		return inactive, nil
+	}
+	clientId := claims.GetClientId()
+	if clientId != "" {
+		_, err = GetAppByAppId(clientId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the subject is the resource owner as in the ID token, tokens without a user are owned by the client
+	subject := clientId
+	if username := claims.GetUsername(); username != "" {
+		user, err := GetUserByUsername(username)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inactive, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		subject = GetUserSubject(user)
+	}
+	return &TokenIntrospection{
+		Active:    true,
+		Subject:   subject,
+		ClientId:  clientId,
+		Scope:     claims.Scope,
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		TokenType: tokenType,
+		Username:  claims.GetUsername(),
+		Audience:  claims.Audience,
+		Act:       claims.Act,
+		Cnf:       claims.Cnf,
+	}, nil
+}
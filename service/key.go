@@ -0,0 +1,521 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/util"
+	"github.com/rs/xid"
+	"gorm.io/gorm"
+)
+
+const (
+	hmacKeyId         = "hs256"
+	keyReloadInterval = 10 * time.Second
+)
+
+var (
+	UnsupportedSigningAlgorithm = errors.New("unsupported signing algorithm")
+	SigningKeyNotFound          = errors.New("signing key not found")
+	SigningKeyMismatch          = errors.New("signing key does not match signing algorithm")
+	KeyRotationUnsupported      = errors.New("key rotation is not supported for HS256")
+	signingKeyRotated           = errors.New("signing key rotated by another instance")
+)
+
+// KeyPair key used to sign and verify tokens
+type KeyPair struct {
+	Kid       string
+	Algorithm string
+	SignKey   interface{}
+	VerifyKey interface{}
+}
+
+func (k *KeyPair) GetSigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// IsAsymmetric whether the public part of the key can be published
+func (k *KeyPair) IsAsymmetric() bool {
+	return k.Algorithm != jwt.SigningMethodHS256.Alg()
+}
+
+var (
+	keyLock      sync.Mutex
+	activeKey    *KeyPair
+	keyPairs     map[string]*KeyPair
+	keysLoaded   bool
+	keysLoadedAt time.Time
+)
+
+// GetActiveKey key used to sign new tokens
+func GetActiveKey() (*KeyPair, error) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	if err := loadKeys(); err != nil {
+		return nil, err
+	}
+	return activeKey, nil
+}
+
+// GetVerificationKey key for the kid in the token header, tokens without kid are legacy HS256 tokens
+// and are only accepted while HS256 is the signing algorithm or during the migration window
+func GetVerificationKey(kid string) (*KeyPair, error) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	if err := loadKeys(); err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		kid = hmacKeyId
+	}
+	if kid == hmacKeyId && !isHmacKeyUsable() {
+		return nil, SigningKeyNotFound
+	}
+	keyPair, ok := keyPairs[kid]
+	if !ok && time.Since(keysLoadedAt) > keyReloadInterval {
+		// key may be rotated by another instance
+		keysLoaded = false
+		if err := loadKeys(); err != nil {
+			return nil, err
+		}
+		keyPair, ok = keyPairs[kid]
+	}
+	if !ok {
+		return nil, SigningKeyNotFound
+	}
+	return keyPair, nil
+}
+
+// GetJWKS public keys of all asymmetric signing keys which are not revoked
+func GetJWKS() (*util.JWKSet, error) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	if err := loadKeys(); err != nil {
+		return nil, err
+	}
+	set := &util.JWKSet{Keys: make([]*util.JWK, 0)}
+	for _, keyPair := range keyPairs {
+		if !keyPair.IsAsymmetric() {
+			continue
+		}
+		jwk, err := util.NewJWK(keyPair.VerifyKey, keyPair.Kid, keyPair.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+func GetSigningKeyList() ([]*database.SigningKey, error) {
+	keys := make([]*database.SigningKey, 0)
+	err := database.Instance.Order("id desc").Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateSigningKey promote the next key to active, retire the current active key and prepare a new next key
+func RotateSigningKey() error {
+	return rotateSigningKey(nil)
+}
+
+// rotateSigningKey instances sharing the database may rotate at the same time, every state change only applies
+// to keys still in the state it read, so only one rotation succeeds and the others are skipped.
+// The rotation is also skipped when expected is given and no longer the active key
+func rotateSigningKey(expected *database.SigningKey) error {
+	algorithm := getSigningAlgorithm()
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		return KeyRotationUnsupported
+	}
+	keyLock.Lock()
+	defer keyLock.Unlock()
+	err := database.Instance.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		retire := map[string]interface{}{"state": database.SigningKeyStateRetiring, "retired_at": now}
+		if expected != nil {
+			result := tx.Model(&database.SigningKey{}).Where("id = ? AND state = ?", expected.ID, database.SigningKeyStateActive).Updates(retire)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return signingKeyRotated
+			}
+		}
+		next := &database.SigningKey{}
+		err := tx.Where("state = ? AND algorithm = ?", database.SigningKeyStateNext, algorithm).Order("id asc").First(next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			next, err = generateSigningKey(tx, algorithm, database.SigningKeyStateNext)
+		}
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&database.SigningKey{}).Where("state = ?", database.SigningKeyStateActive).Updates(retire).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&database.SigningKey{}).
+			Where("id = ? AND state = ?", next.ID, database.SigningKeyStateNext).
+			Updates(map[string]interface{}{"state": database.SigningKeyStateActive, "activated_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return signingKeyRotated
+		}
+		_, err = generateSigningKey(tx, algorithm, database.SigningKeyStateNext)
+		return err
+	})
+	keysLoaded = false
+	if errors.Is(err, signingKeyRotated) {
+		Logger.Info("signing key already rotated by another instance")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	Logger.Info("signing key rotated")
+	return nil
+}
+
+// RunKeyRotationScheduler check signing keys periodically, rotate the active key after token.keyRotationInterval
+// and revoke retiring keys after token.keyRetirePeriod
+func RunKeyRotationScheduler() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if database.Instance == nil {
+				continue
+			}
+			if err := checkSigningKeys(); err != nil {
+				Logger.Error(err.Error())
+			}
+		}
+	}()
+}
+
+func checkSigningKeys() error {
+	jwtConfig := config.Instance.JWTConfig
+	algorithm := getSigningAlgorithm()
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		return nil
+	}
+	now := time.Now()
+	if jwtConfig.KeyRotationInterval > 0 {
+		active := &database.SigningKey{}
+		err := database.Instance.Where("state = ? AND algorithm = ?", database.SigningKeyStateActive, algorithm).Order("id desc").First(active).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			activatedAt := active.CreatedAt
+			if active.ActivatedAt != nil {
+				activatedAt = *active.ActivatedAt
+			}
+			if activatedAt.Add(time.Duration(jwtConfig.KeyRotationInterval) * time.Second).Before(now) {
+				if err = rotateSigningKey(active); err != nil {
+					return err
+				}
+			}
+		}
+		// publish the next key in advance so verifiers can cache it before it is used
+		var nextCount int64
+		err = database.Instance.Model(&database.SigningKey{}).Where("state = ? AND algorithm = ?", database.SigningKeyStateNext, algorithm).Count(&nextCount).Error
+		if err != nil {
+			return err
+		}
+		if nextCount == 0 {
+			if _, err = generateSigningKey(database.Instance, algorithm, database.SigningKeyStateNext); err != nil {
+				return err
+			}
+		}
+	}
+	retirePeriod := jwtConfig.KeyRetirePeriod
+	if retirePeriod <= 0 {
+		// keep retiring keys until every token signed by them is expired
+		retirePeriod = jwtConfig.AccessTokenExpire
+		if jwtConfig.RefreshTokenExpire > retirePeriod {
+			retirePeriod = jwtConfig.RefreshTokenExpire
+		}
+	}
+	err := database.Instance.Model(&database.SigningKey{}).
+		Where("state = ? AND retired_at < ?", database.SigningKeyStateRetiring, now.Add(-time.Duration(retirePeriod)*time.Second)).
+		Update("state", database.SigningKeyStateRevoked).Error
+	if err != nil {
+		return err
+	}
+	if !isHmacKeyUsable() {
+		// the migration window of the legacy HS256 key is over
+		err = database.Instance.Model(&database.SigningKey{}).
+			Where("kid = ? AND state <> ?", hmacKeyId, database.SigningKeyStateRevoked).
+			Update("state", database.SigningKeyStateRevoked).Error
+		if err != nil {
+			return err
+		}
+	}
+	keyLock.Lock()
+	keysLoaded = false
+	keyLock.Unlock()
+	return nil
+}
+
+func signToken(claims jwt.Claims) (string, error) {
+	keyPair, err := GetActiveKey()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(keyPair.GetSigningMethod(), claims)
+	token.Header["kid"] = keyPair.Kid
+	return token.SignedString(keyPair.SignKey)
+}
+
+func getSigningAlgorithm() string {
+	if config.Instance.JWTConfig.SigningAlgorithm == "" {
+		return jwt.SigningMethodRS256.Alg()
+	}
+	return config.Instance.JWTConfig.SigningAlgorithm
+}
+
+// isHmacKeyUsable token.secret verifies tokens when HS256 is the signing algorithm, after switching to an asymmetric
+// algorithm everyone holding the secret could still forge tokens, so it is only accepted until token.legacyHs256Until
+func isHmacKeyUsable() bool {
+	if getSigningAlgorithm() == jwt.SigningMethodHS256.Alg() {
+		return true
+	}
+	return time.Now().Before(config.Instance.JWTConfig.GetLegacyHS256Until())
+}
+
+func loadKeys() error {
+	if keysLoaded {
+		return nil
+	}
+	jwtConfig := config.Instance.JWTConfig
+	algorithm := getSigningAlgorithm()
+	pairs := map[string]*KeyPair{}
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		if jwtConfig.Secret != "" {
+			pairs[hmacKeyId] = newHmacKeyPair(jwtConfig.Secret)
+		}
+	} else if jwtConfig.Secret != "" && isHmacKeyUsable() {
+		if err := importLegacySecret(jwtConfig.Secret); err != nil {
+			return err
+		}
+	}
+	if algorithm != jwt.SigningMethodHS256.Alg() && jwtConfig.SigningKeyFile != "" {
+		if err := importSigningKeyFile(jwtConfig.SigningKeyFile, algorithm); err != nil {
+			return err
+		}
+	}
+	var records []*database.SigningKey
+	err := database.Instance.Where("state <> ?", database.SigningKeyStateRevoked).Order("id desc").Find(&records).Error
+	if err != nil {
+		return err
+	}
+	var active *KeyPair
+	for _, record := range records {
+		if record.Algorithm == jwt.SigningMethodHS256.Alg() {
+			// the active HS256 key always comes from token.secret, the stored one is the legacy key
+			if algorithm != jwt.SigningMethodHS256.Alg() && isHmacKeyUsable() {
+				pairs[record.Kid] = newHmacKeyPair(record.PrivateKey)
+			}
+			continue
+		}
+		keyPair, err := newKeyPairFromPEM(record.Kid, record.Algorithm, []byte(record.PrivateKey))
+		if err != nil {
+			return err
+		}
+		pairs[keyPair.Kid] = keyPair
+		if active == nil && record.State == database.SigningKeyStateActive && keyPair.Algorithm == algorithm {
+			active = keyPair
+		}
+	}
+	if algorithm == jwt.SigningMethodHS256.Alg() {
+		active = pairs[hmacKeyId]
+		if active == nil {
+			return SigningKeyNotFound
+		}
+	} else if active == nil {
+		record, err := generateSigningKey(database.Instance, algorithm, database.SigningKeyStateActive)
+		if err != nil {
+			return err
+		}
+		active, err = newKeyPairFromPEM(record.Kid, record.Algorithm, []byte(record.PrivateKey))
+		if err != nil {
+			return err
+		}
+		pairs[active.Kid] = active
+	}
+	activeKey = active
+	keyPairs = pairs
+	keysLoaded = true
+	keysLoadedAt = time.Now()
+	return nil
+}
+
+func newHmacKeyPair(secret string) *KeyPair {
+	return &KeyPair{
+		Kid:       hmacKeyId,
+		Algorithm: jwt.SigningMethodHS256.Alg(),
+		SignKey:   []byte(secret),
+		VerifyKey: []byte(secret),
+	}
+}
+
+// importLegacySecret store token.secret as a retiring key after switching to an asymmetric algorithm,
+// so it is revoked like other retiring keys, only on first use
+func importLegacySecret(secret string) error {
+	var count int64
+	err := database.Instance.Model(&database.SigningKey{}).Where("kid = ?", hmacKeyId).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	now := time.Now()
+	return database.Instance.Create(&database.SigningKey{
+		Kid:        hmacKeyId,
+		Algorithm:  jwt.SigningMethodHS256.Alg(),
+		PrivateKey: secret,
+		State:      database.SigningKeyStateRetiring,
+		RetiredAt:  &now,
+	}).Error
+}
+
+// importSigningKeyFile add the configured key file to the key ring as the active key, only on first use
+func importSigningKeyFile(path string, algorithm string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	keyPair, err := newKeyPairFromPEM("", algorithm, raw)
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = database.Instance.Model(&database.SigningKey{}).Where("kid = ?", keyPair.Kid).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return database.Instance.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&database.SigningKey{}).
+			Where("state = ?", database.SigningKeyStateActive).
+			Updates(map[string]interface{}{"state": database.SigningKeyStateRetiring, "retired_at": now}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&database.SigningKey{
+			Kid:         keyPair.Kid,
+			Algorithm:   algorithm,
+			PrivateKey:  string(raw),
+			State:       database.SigningKeyStateActive,
+			ActivatedAt: &now,
+		}).Error
+	})
+}
+
+func generateSigningKey(tx *gorm.DB, algorithm string, state string) (*database.SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case util.SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, UnsupportedSigningAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	record := &database.SigningKey{
+		Kid:        xid.New().String(),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		State:      state,
+	}
+	if state == database.SigningKeyStateActive {
+		now := time.Now()
+		record.ActivatedAt = &now
+	}
+	err = tx.Create(record).Error
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// newKeyPairFromPEM parse private key in PEM format, the kid defaults to the JWK thumbprint of the public key
+func newKeyPairFromPEM(kid string, algorithm string, raw []byte) (*KeyPair, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyPair := &KeyPair{
+		Kid:       kid,
+		Algorithm: algorithm,
+		SignKey:   privateKey,
+	}
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != jwt.SigningMethodRS256.Alg() {
+			return nil, SigningKeyMismatch
+		}
+		keyPair.VerifyKey = &key.PublicKey
+	case *ecdsa.PrivateKey:
+		if algorithm != jwt.SigningMethodES256.Alg() || key.Curve != elliptic.P256() {
+			return nil, SigningKeyMismatch
+		}
+		keyPair.VerifyKey = &key.PublicKey
+	case ed25519.PrivateKey:
+		if algorithm != util.SigningMethodEdDSA.Alg() {
+			return nil, SigningKeyMismatch
+		}
+		keyPair.VerifyKey = key.Public()
+	default:
+		return nil, UnsupportedSigningAlgorithm
+	}
+	if keyPair.Kid == "" {
+		jwk, err := util.NewJWK(keyPair.VerifyKey, "", algorithm)
+		if err != nil {
+			return nil, err
+		}
+		keyPair.Kid, err = jwk.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyPair, nil
+}
@@ -0,0 +1,7 @@
+package service
+
+import log "github.com/sirupsen/logrus"
+
+var Logger = log.New().WithFields(log.Fields{
+	"scope": "Service",
+})
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	backchannelLogoutEvent    = "http://schemas.openid.net/event/backchannel-logout"
+	backchannelLogoutAttempts = 5
+	// backchannelLogoutWorkers number of notifications delivered at the same time
+	backchannelLogoutWorkers = 4
+	backchannelLogoutTimeout = 10 * time.Second
+	logoutTokenExpire        = 2 * time.Minute
+)
+
+var (
+	InvalidatePostLogoutRedirectUri = errors.New("invalid post logout redirect uri")
+	InvalidateIdTokenHint           = errors.New("invalid id_token_hint")
+)
+
+var backchannelLogoutClient = &http.Client{Timeout: backchannelLogoutTimeout}
+
+// backchannelLogoutWake wake the worker when new notifications are queued
+var backchannelLogoutWake = make(chan struct{}, 1)
+
+// LogoutTokenClaim logout token of OpenID Connect Back-Channel Logout
+type LogoutTokenClaim struct {
+	jwt.StandardClaims
+	Sid    string                 `json:"sid,omitempty"`
+	Events map[string]interface{} `json:"events"`
+}
+
+// ParseIdTokenHint id token previously issued by us, the hint is accepted after it expired
+func ParseIdTokenHint(tokenString string) (*IdTokenClaim, error) {
+	claims := &IdTokenClaim{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		keyPair, err := GetVerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != keyPair.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return keyPair.VerifyKey, nil
+	})
+	if err != nil {
+		jwtErr, ok := err.(*jwt.ValidationError)
+		if !ok || jwtErr.Errors != jwt.ValidationErrorExpired {
+			return nil, InvalidateIdTokenHint
+		}
+	}
+	if claims.Issuer != config.Instance.JWTConfig.GetIssuer() || claims.Audience == "" {
+		return nil, InvalidateIdTokenHint
+	}
+	return claims, nil
+}
+
+// IsIdTokenHintOfSession whether the hint was issued to the user of the session within the session,
+// anyone can present an old id token of their own, it only proves the logout was requested by an app of the session
+func IsIdTokenHintOfSession(claims *IdTokenClaim, session *database.Session) bool {
+	return session != nil && session.User != nil && claims.Sid != "" &&
+		claims.Sid == session.Sid && claims.Subject == GetUserSubject(session.User)
+}
+
+// CheckPostLogoutRedirectUri the uri must be registered by the app exactly
+func CheckPostLogoutRedirectUri(app *database.App, uri string) error {
+	if !HasScope(app.PostLogoutRedirectUris, uri) {
+		return InvalidatePostLogoutRedirectUri
+	}
+	return nil
+}
+
+func checkLogoutUris(postLogoutRedirectUris []string, frontchannelLogoutUri string, backchannelLogoutUri string) error {
+	uris := append([]string{}, postLogoutRedirectUris...)
+	for _, uri := range []string{frontchannelLogoutUri, backchannelLogoutUri} {
+		if uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return checkRedirectUris(uris)
+}
+
+// GetFrontchannelLogoutUrl url loaded in an iframe to tell the app the session ended
+func GetFrontchannelLogoutUrl(app *database.App, sid string) (string, error) {
+	u, err := url.Parse(app.FrontchannelLogoutUri)
+	if err != nil {
+		return "", err
+	}
+	qry := u.Query()
+	qry.Set("iss", config.Instance.JWTConfig.GetIssuer())
+	qry.Set("sid", sid)
+	u.RawQuery = qry.Encode()
+	return u.String(), nil
+}
+
+// EndSession end the SSO session of the cookie value and return the apps signed in through it.
+// Apps with a back-channel logout uri are queued for notification, nil session if the session does not exist
+func EndSession(token string) (*database.Session, []*database.App, error) {
+	session := &database.Session{}
+	err := database.Instance.Preload("User").Where("token_hash = ?", hashOpaqueToken(token)).Limit(1).Find(session).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.ID == 0 {
+		return nil, nil, nil
+	}
+	var sessionApps []*database.SessionApp
+	err = database.Instance.Preload("App").Where("session_id = ?", session.ID).Find(&sessionApps).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = database.Instance.Unscoped().Where("session_id = ?", session.ID).Delete(&database.SessionApp{}).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	err = database.Instance.Unscoped().Delete(session).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	apps := make([]*database.App, 0)
+	queued := false
+	for _, sessionApp := range sessionApps {
+		if sessionApp.App == nil {
+			continue
+		}
+		apps = append(apps, sessionApp.App)
+		if sessionApp.App.BackchannelLogoutUri != "" && session.User != nil {
+			err = database.Instance.Create(&database.BackchannelLogout{
+				AppId:         &sessionApp.App.ID,
+				UserId:        &session.User.ID,
+				Sid:           session.Sid,
+				NextAttemptAt: time.Now(),
+			}).Error
+			if err != nil {
+				return nil, nil, err
+			}
+			queued = true
+		}
+	}
+	if queued {
+		select {
+		case backchannelLogoutWake <- struct{}{}:
+		default:
+		}
+	}
+	return session, apps, nil
+}
+
+func newLogoutToken(app *database.App, user *database.User, sid string) (string, error) {
+	now := time.Now()
+	claims := &LogoutTokenClaim{
+		StandardClaims: jwt.StandardClaims{
+			Id:        xid.New().String(),
+			Issuer:    config.Instance.JWTConfig.GetIssuer(),
+			Subject:   GetUserSubject(user),
+			Audience:  app.AppId,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(logoutTokenExpire).Unix(),
+		},
+		Sid:    sid,
+		Events: map[string]interface{}{backchannelLogoutEvent: map[string]interface{}{}},
+	}
+	return signToken(claims)
+}
+
+// RunBackchannelLogoutWorker deliver queued back-channel logout notifications until ctx is done.
+// Notifications are persisted, so the ones pending on shutdown are delivered after restart
+func RunBackchannelLogoutWorker(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-backchannelLogoutWake:
+			}
+			if database.Instance == nil {
+				continue
+			}
+			if err := deliverBackchannelLogouts(ctx); err != nil {
+				Logger.Error(err.Error())
+			}
+		}
+	}()
+}
+
+// deliverBackchannelLogouts deliver the due notifications, at most backchannelLogoutWorkers at the same time
+func deliverBackchannelLogouts(ctx context.Context) error {
+	var pending []*database.BackchannelLogout
+	err := database.Instance.Preload("App").Preload("User").
+		Where("next_attempt_at <= ?", time.Now()).
+		Order("next_attempt_at").
+		Limit(backchannelLogoutWorkers * 8).
+		Find(&pending).Error
+	if err != nil {
+		return err
+	}
+	slots := make(chan struct{}, backchannelLogoutWorkers)
+	var wg sync.WaitGroup
+	for _, notification := range pending {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(notification *database.BackchannelLogout) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			sendBackchannelLogout(ctx, notification)
+		}(notification)
+	}
+	wg.Wait()
+	return nil
+}
+
+// sendBackchannelLogout POST the logout token to the app, failed notifications are retried with exponential backoff
+func sendBackchannelLogout(ctx context.Context, notification *database.BackchannelLogout) {
+	if notification.App == nil || notification.User == nil || notification.App.BackchannelLogoutUri == "" {
+		// the app or the user is removed, nothing to notify
+		database.Instance.Unscoped().Delete(notification)
+		return
+	}
+	logger := Logger.WithFields(log.Fields{"app": notification.App.AppId, "sid": notification.Sid})
+	err := postLogoutToken(ctx, notification.App, notification.User, notification.Sid)
+	if err == nil {
+		database.Instance.Unscoped().Delete(notification)
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down, the notification is retried after restart
+		return
+	}
+	attempts := notification.Attempts + 1
+	logger.Warnf("back-channel logout attempt %d failed: %s", attempts, err.Error())
+	if attempts >= backchannelLogoutAttempts {
+		logger.Error("back-channel logout failed, giving up")
+		database.Instance.Unscoped().Delete(notification)
+		return
+	}
+	delay := time.Second << uint(attempts)
+	database.Instance.Model(notification).Updates(map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": time.Now().Add(delay),
+	})
+}
+
+func postLogoutToken(ctx context.Context, app *database.App, user *database.User, sid string) error {
+	logoutToken, err := newLogoutToken(app, user, sid)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, backchannelLogoutTimeout)
+	defer cancel()
+	body := url.Values{"logout_token": {logoutToken}}.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, app.BackchannelLogoutUri, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := backchannelLogoutClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/projectxpolaris/youauth/util"
+)
+
+const (
+	ScopeOpenId  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+type IdTokenClaim struct {
+	jwt.StandardClaims
+	Nonce             string `json:"nonce,omitempty"`
+	AuthTime          int64  `json:"auth_time,omitempty"`
+	AtHash            string `json:"at_hash,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	Sid               string `json:"sid,omitempty"`
+}
+
+// HasScope whether the space separated scope contains name
+func HasScope(scope string, name string) bool {
+	for _, item := range strings.Fields(scope) {
+		if item == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserSubject stable subject identifier of the user
+func GetUserSubject(user *database.User) string {
+	return strconv.FormatUint(uint64(user.ID), 10)
+}
+
+func newIdToken(user *database.User, app *database.App, authCode *database.AuthorizationCode, accessToken string) (string, error) {
+	now := time.Now()
+	claims := &IdTokenClaim{
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    config.Instance.JWTConfig.GetIssuer(),
+			Subject:   GetUserSubject(user),
+			Audience:  app.AppId,
+			ExpiresAt: now.Add(time.Duration(config.Instance.JWTConfig.IdTokenExpire) * time.Second).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Nonce: authCode.Nonce,
+		Sid:   authCode.Sid,
+	}
+	if authCode.AuthTime != nil {
+		claims.AuthTime = authCode.AuthTime.Unix()
+	}
+	keyPair, err := GetActiveKey()
+	if err != nil {
+		return "", err
+	}
+	if accessToken != "" {
+		claims.AtHash = tokenHash(keyPair.Algorithm, accessToken)
+	}
+	if HasScope(authCode.Scope, ScopeProfile) {
+		claims.Name = user.Username
+		claims.PreferredUsername = user.Username
+	}
+	if HasScope(authCode.Scope, ScopeEmail) && user.Email != "" {
+		verified := false
+		claims.Email = user.Email
+		claims.EmailVerified = &verified
+	}
+	return signToken(claims)
+}
+
+// tokenHash left-most half of the hash of the token, as used by at_hash (OIDC Core 3.1.3.6)
+func tokenHash(algorithm string, token string) string {
+	var h hash.Hash
+	if algorithm == util.SigningMethodEdDSA.Alg() {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"gorm.io/gorm"
+)
+
+const requestUriPrefix = "urn:ietf:params:oauth:request_uri:"
+
+var (
+	InvalidateRequestUri     = errors.New("invalid or expired request uri")
+	ParRequired              = errors.New("the app requires pushed authorization requests")
+	UnsupportedResponseType  = errors.New("only response_type code is supported")
+	InvalidateParRequest     = errors.New("request_uri is not allowed in a pushed authorization request")
+	InvalidateParRequirement = errors.New("public clients can not require pushed authorization requests")
+)
+
+// checkParRequirement pushed authorization requests are only accepted from confidential clients,
+// a public client requiring them could never authorize
+func checkParRequirement(app *database.App) error {
+	if app.RequirePar && IsPublicClient(app) {
+		return InvalidateParRequirement
+	}
+	return nil
+}
+
+// clientAuthParameters parameters only used to authenticate the client, never stored with the request
+var clientAuthParameters = []string{"client_secret", "client_assertion", "client_assertion_type"}
+
+// PushAuthorizationRequest validate the authorization parameters of the app and store them behind a request uri.
+// The same checks run again when the request is used at /login
+func PushAuthorizationRequest(app *database.App, params url.Values) (*database.PushedAuthorizationRequest, error) {
+	if IsPublicClient(app) {
+		return nil, UnauthorizedClient
+	}
+	if params.Get("request_uri") != "" {
+		return nil, InvalidateParRequest
+	}
+	if request := params.Get("request"); request != "" {
+		var err error
+		params, err = ParseRequestObject(app, request)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params.Get("response_type") != "code" {
+		return nil, UnsupportedResponseType
+	}
+	redirectUri := params.Get("redirect_uri")
+	if redirectUri == "" {
+		redirectUri = params.Get("redirect_url")
+	}
+	redirectUri, err := ResolveRedirectUri(app, redirectUri)
+	if err != nil {
+		return nil, err
+	}
+	option := AuthCodeOption{
+		Scope:               params.Get("scope"),
+		CodeChallenge:       params.Get("code_challenge"),
+		CodeChallengeMethod: params.Get("code_challenge_method"),
+	}
+	err = checkAuthCodeOption(app, &option)
+	if err != nil {
+		return nil, err
+	}
+	stored := url.Values{}
+	for key, values := range params {
+		stored[key] = values
+	}
+	for _, key := range clientAuthParameters {
+		stored.Del(key)
+	}
+	stored.Del("redirect_url")
+	stored.Set("client_id", app.AppId)
+	stored.Set("redirect_uri", redirectUri)
+	stored.Set("scope", option.Scope)
+	requestId, err := newRequestId()
+	if err != nil {
+		return nil, err
+	}
+	request := &database.PushedAuthorizationRequest{
+		RequestUri: requestUriPrefix + requestId,
+		AppId:      &app.ID,
+		Parameters: stored.Encode(),
+		ExpiresAt:  time.Now().Add(time.Duration(config.Instance.JWTConfig.ParExpire) * time.Second),
+	}
+	err = database.Instance.Create(request).Error
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// GetPushedAuthorizationParameters authorization parameters behind the request uri, the request must belong to the app.
+// The request stays valid until it expires or is used to issue a code, so that the login form can be submitted again
+func GetPushedAuthorizationParameters(app *database.App, requestUri string) (url.Values, error) {
+	request := &database.PushedAuthorizationRequest{}
+	err := database.Instance.Where("request_uri = ?", requestUri).First(request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InvalidateRequestUri
+	}
+	if err != nil {
+		return nil, err
+	}
+	if request.AppId == nil || *request.AppId != app.ID || time.Now().After(request.ExpiresAt) {
+		return nil, InvalidateRequestUri
+	}
+	return url.ParseQuery(request.Parameters)
+}
+
+// RemovePushedAuthorizationRequest the request uri is single use once the user has been authorized
+func RemovePushedAuthorizationRequest(requestUri string) error {
+	return database.Instance.Unscoped().Where("request_uri = ?", requestUri).Delete(&database.PushedAuthorizationRequest{}).Error
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+
+	"github.com/projectxpolaris/youauth/database"
+)
+
+const (
+	CodeChallengeMethodS256  = "S256"
+	CodeChallengeMethodPlain = "plain"
+)
+
+var (
+	CodeChallengeRequired         = errors.New("code challenge required")
+	InvalidateCodeChallengeMethod = errors.New("invalid code challenge method")
+	InvalidateCodeVerifier        = errors.New("invalid code verifier")
+	codeVerifierPattern           = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+)
+
+// checkCodeChallenge validate PKCE parameters of the authorization request (RFC 7636 section 4.3)
+func checkCodeChallenge(app *database.App, option *AuthCodeOption) error {
+	if option.CodeChallenge == "" {
+		if app.RequirePkce {
+			return CodeChallengeRequired
+		}
+		if option.CodeChallengeMethod != "" {
+			return InvalidateCodeChallengeMethod
+		}
+		return nil
+	}
+	if option.CodeChallengeMethod == "" {
+		option.CodeChallengeMethod = CodeChallengeMethodPlain
+	}
+	if option.CodeChallengeMethod != CodeChallengeMethodS256 && option.CodeChallengeMethod != CodeChallengeMethodPlain {
+		return InvalidateCodeChallengeMethod
+	}
+	if !codeVerifierPattern.MatchString(option.CodeChallenge) {
+		return InvalidateCodeChallengeMethod
+	}
+	return nil
+}
+
+// verifyCodeVerifier check code_verifier against the challenge saved with the auth code (RFC 7636 section 4.6)
+func verifyCodeVerifier(authCode *database.AuthorizationCode, codeVerifier string) error {
+	if authCode.CodeChallenge == "" {
+		if codeVerifier != "" {
+			return InvalidateCodeVerifier
+		}
+		return nil
+	}
+	if !codeVerifierPattern.MatchString(codeVerifier) {
+		return InvalidateCodeVerifier
+	}
+	expected := codeVerifier
+	if authCode.CodeChallengeMethod == CodeChallengeMethodS256 {
+		sum := sha256.Sum256([]byte(codeVerifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(authCode.CodeChallenge)) != 1 {
+		return InvalidateCodeVerifier
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/projectxpolaris/youauth/database"
+)
+
+var InvalidateRedirectUri = errors.New("invalid redirect uri")
+
+// GetRedirectUris registered redirect uris of the app, the legacy callback counts as a registered uri
+func GetRedirectUris(app *database.App) []string {
+	uris := strings.Fields(app.RedirectUris)
+	if app.Callback != "" {
+		for _, uri := range uris {
+			if uri == app.Callback {
+				return uris
+			}
+		}
+		uris = append(uris, app.Callback)
+	}
+	return uris
+}
+
+// ResolveRedirectUri validate the redirect uri of the authorization request,
+// an empty redirect uri resolves to the registered one when the app registers exactly one
+func ResolveRedirectUri(app *database.App, redirectUri string) (string, error) {
+	registered := GetRedirectUris(app)
+	if redirectUri == "" {
+		if len(registered) == 1 {
+			return registered[0], nil
+		}
+		return "", InvalidateRedirectUri
+	}
+	for _, uri := range registered {
+		if uri == redirectUri {
+			return redirectUri, nil
+		}
+		if app.AllowLoopbackPort && matchLoopbackUri(uri, redirectUri) {
+			return redirectUri, nil
+		}
+	}
+	return "", InvalidateRedirectUri
+}
+
+// matchLoopbackUri loopback redirect uris of native apps may use any port (RFC 8252 section 7.3)
+func matchLoopbackUri(registered string, redirectUri string) bool {
+	registeredUrl, err := url.Parse(registered)
+	if err != nil {
+		return false
+	}
+	redirectUrl, err := url.Parse(redirectUri)
+	if err != nil {
+		return false
+	}
+	if registeredUrl.Scheme != "http" || redirectUrl.Scheme != "http" || !isLoopbackHost(registeredUrl.Hostname()) {
+		return false
+	}
+	return registeredUrl.Hostname() == redirectUrl.Hostname() &&
+		registeredUrl.EscapedPath() == redirectUrl.EscapedPath() &&
+		registeredUrl.RawQuery == redirectUrl.RawQuery &&
+		redirectUrl.User == nil &&
+		redirectUrl.Fragment == ""
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkRedirectUris registered uris must be absolute and must not contain a fragment (RFC 6749 section 3.1.2)
+func checkRedirectUris(uris []string) error {
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " \t\n") {
+			return InvalidateRedirectUri
+		}
+	}
+	return nil
+}
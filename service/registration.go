@@ -0,0 +1,262 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/projectxpolaris/youauth/database"
+	"gorm.io/gorm"
+)
+
+var (
+	InvalidateInitialAccessToken = errors.New("invalid or expired initial access token")
+	InvalidateRegistrationToken  = errors.New("invalid registration access token")
+	InvalidateClientMetadata     = errors.New("invalid client metadata")
+)
+
+// registrableGrantTypes grant types a client may register for, token exchange needs audiences set by an admin
+// and the password grant hands the credentials of users to the client, so only admins may allow them
+var registrableGrantTypes = []string{
+	"authorization_code",
+	"refresh_token",
+	"client_credentials",
+	"urn:ietf:params:oauth:grant-type:device_code",
+}
+
+// ClientMetadata client metadata of dynamic client registration (RFC 7591 section 2)
+type ClientMetadata struct {
+	RedirectUris            []string
+	TokenEndpointAuthMethod string
+	GrantTypes              []string
+	ResponseTypes           []string
+	ClientName              string
+	Scope                   string
+	Jwks                    string
+	JwksUri                 string
+	PostLogoutRedirectUris  []string
+	FrontchannelLogoutUri   string
+	BackchannelLogoutUri    string
+	RequirePar              bool
+}
+
+func (m *ClientMetadata) getGrantTypes() []string {
+	if len(m.GrantTypes) == 0 {
+		return []string{"authorization_code"}
+	}
+	return m.GrantTypes
+}
+
+// getResponseTypes response types default to code for clients using the authorization_code grant (RFC 7591 section 2.1)
+func (m *ClientMetadata) getResponseTypes() []string {
+	if len(m.ResponseTypes) > 0 {
+		return m.ResponseTypes
+	}
+	for _, grantType := range m.getGrantTypes() {
+		if grantType == "authorization_code" {
+			return []string{"code"}
+		}
+	}
+	return []string{}
+}
+
+// getClientCredentialsScopes the registered scope also applies to the client_credentials grant when the client uses it
+func (m *ClientMetadata) getClientCredentialsScopes() []string {
+	for _, grantType := range m.getGrantTypes() {
+		if grantType == "client_credentials" {
+			return strings.Fields(m.Scope)
+		}
+	}
+	return []string{}
+}
+
+func checkClientMetadata(metadata *ClientMetadata) error {
+	grantTypes := strings.Join(metadata.getGrantTypes(), " ")
+	responseTypes := strings.Join(metadata.getResponseTypes(), " ")
+	// the code response type and the authorization_code grant are only useful together
+	if HasScope(grantTypes, "authorization_code") != HasScope(responseTypes, "code") {
+		return InvalidateClientMetadata
+	}
+	for _, grantType := range metadata.getGrantTypes() {
+		if !HasScope(strings.Join(registrableGrantTypes, " "), grantType) {
+			return InvalidateClientMetadata
+		}
+		if grantType == "authorization_code" && len(metadata.RedirectUris) == 0 {
+			return InvalidateRedirectUri
+		}
+		if grantType == "client_credentials" && metadata.TokenEndpointAuthMethod == ClientAuthMethodNone {
+			return InvalidateClientMetadata
+		}
+	}
+	for _, responseType := range metadata.getResponseTypes() {
+		if responseType != "code" {
+			return InvalidateClientMetadata
+		}
+	}
+	return nil
+}
+
+// CreateInitialAccessToken issue a token for registering clients, the token is only returned here
+func CreateInitialAccessToken(userId uint, description string, expiresIn int64, maxUses int) (string, *database.InitialAccessToken, error) {
+	token, err := newRequestId()
+	if err != nil {
+		return "", nil, err
+	}
+	record := &database.InitialAccessToken{
+		TokenHash:   hashOpaqueToken(token),
+		UserId:      &userId,
+		Description: description,
+		MaxUses:     maxUses,
+	}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(time.Duration(expiresIn) * time.Second)
+		record.ExpiresAt = &expiresAt
+	}
+	err = database.Instance.Create(record).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+func GetInitialAccessTokenList() ([]*database.InitialAccessToken, error) {
+	tokens := make([]*database.InitialAccessToken, 0)
+	err := database.Instance.Order("id desc").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RemoveInitialAccessToken revoke the initial access token, clients registered with it are kept
+func RemoveInitialAccessToken(id uint) error {
+	return database.Instance.Unscoped().Delete(&database.InitialAccessToken{}, id).Error
+}
+
+func getInitialAccessToken(token string) (*database.InitialAccessToken, error) {
+	record := &database.InitialAccessToken{}
+	err := database.Instance.Where("token_hash = ?", hashOpaqueToken(token)).First(record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InvalidateInitialAccessToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if record.UserId == nil || (record.ExpiresAt != nil && time.Now().After(*record.ExpiresAt)) {
+		return nil, InvalidateInitialAccessToken
+	}
+	return record, nil
+}
+
+// useInitialAccessToken count a registration against the token, fails once the token has been used up.
+// The check and the increment are a single conditional update, so concurrent registrations can not exceed the limit
+func useInitialAccessToken(tx *gorm.DB, record *database.InitialAccessToken) error {
+	result := tx.Model(&database.InitialAccessToken{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", record.ID).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return InvalidateInitialAccessToken
+	}
+	return nil
+}
+
+// RegisterClient register a client with an initial access token, the app is owned by the admin who issued the token.
+// Return the app and its registration access token
+func RegisterClient(initialAccessToken string, metadata ClientMetadata) (*database.App, string, error) {
+	record, err := getInitialAccessToken(initialAccessToken)
+	if err != nil {
+		return nil, "", err
+	}
+	err = checkClientMetadata(&metadata)
+	if err != nil {
+		return nil, "", err
+	}
+	registrationToken, err := newRequestId()
+	if err != nil {
+		return nil, "", err
+	}
+	option := CreateAppOption{
+		Name:                    metadata.ClientName,
+		RedirectUris:            metadata.RedirectUris,
+		RequirePar:              metadata.RequirePar,
+		TokenEndpointAuthMethod: metadata.TokenEndpointAuthMethod,
+		AllowedScopes:           strings.Fields(metadata.Scope),
+		ClientCredentialsScopes: metadata.getClientCredentialsScopes(),
+		PostLogoutRedirectUris:  metadata.PostLogoutRedirectUris,
+		FrontchannelLogoutUri:   metadata.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    metadata.BackchannelLogoutUri,
+		Jwks:                    metadata.Jwks,
+		JwksUri:                 metadata.JwksUri,
+		GrantTypes:              metadata.getGrantTypes(),
+		ResponseTypes:           metadata.getResponseTypes(),
+	}
+	// a use of the token is only counted when the app is created
+	var app *database.App
+	err = database.Instance.Transaction(func(tx *gorm.DB) error {
+		err := useInitialAccessToken(tx, record)
+		if err != nil {
+			return err
+		}
+		app, err = createApp(tx, option, *record.UserId)
+		if err != nil {
+			return err
+		}
+		app.RegistrationTokenHash = hashOpaqueToken(registrationToken)
+		return tx.Model(app).Update("registration_token_hash", app.RegistrationTokenHash).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return app, registrationToken, nil
+}
+
+// GetRegisteredClient app of the client configuration endpoint (RFC 7592), the registration access token must belong to it
+func GetRegisteredClient(clientId string, registrationToken string) (*database.App, error) {
+	app, err := GetAppByAppId(clientId)
+	if err != nil {
+		return nil, InvalidateRegistrationToken
+	}
+	if app.RegistrationTokenHash == "" || app.UserId == nil ||
+		subtle.ConstantTimeCompare([]byte(hashOpaqueToken(registrationToken)), []byte(app.RegistrationTokenHash)) != 1 {
+		return nil, InvalidateRegistrationToken
+	}
+	return app, nil
+}
+
+// UpdateRegisteredClient replace the metadata of the client, omitted fields are cleared (RFC 7592 section 2.2)
+func UpdateRegisteredClient(app *database.App, metadata ClientMetadata) (*database.App, error) {
+	if app.UserId == nil {
+		return nil, InvalidateRegistrationToken
+	}
+	err := checkClientMetadata(&metadata)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateApp(app.AppId, *app.UserId, false, UpdateAppOption{
+		Name:                    &metadata.ClientName,
+		RedirectUris:            append([]string{}, metadata.RedirectUris...),
+		RequirePar:              &metadata.RequirePar,
+		TokenEndpointAuthMethod: &metadata.TokenEndpointAuthMethod,
+		AllowedScopes:           strings.Fields(metadata.Scope),
+		ClientCredentialsScopes: metadata.getClientCredentialsScopes(),
+		PostLogoutRedirectUris:  append([]string{}, metadata.PostLogoutRedirectUris...),
+		FrontchannelLogoutUri:   &metadata.FrontchannelLogoutUri,
+		BackchannelLogoutUri:    &metadata.BackchannelLogoutUri,
+		Jwks:                    &metadata.Jwks,
+		JwksUri:                 &metadata.JwksUri,
+		GrantTypes:              metadata.getGrantTypes(),
+		ResponseTypes:           metadata.getResponseTypes(),
+	})
+}
+
+// RemoveRegisteredClient deregister the client (RFC 7592 section 2.3)
+func RemoveRegisteredClient(app *database.App) error {
+	if app.UserId == nil {
+		return InvalidateRegistrationToken
+	}
+	return RemoveAppByAppId(app.AppId, *app.UserId)
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/projectxpolaris/youauth/database"
+	"gorm.io/gorm"
+)
+
+const ScopeOfflineAccess = "offline_access"
+
+// BuiltInScopes scopes understood by the server itself
+var BuiltInScopes = []*database.Scope{
+	{Name: ScopeOpenId, Description: "sign in with OpenID Connect"},
+	{Name: ScopeProfile, Description: "read the name of the user"},
+	{Name: ScopeEmail, Description: "read the email of the user"},
+	{Name: ScopeOfflineAccess, Description: "keep access when the user is not present"},
+}
+
+var (
+	InvalidateScopeName  = errors.New("invalid scope name")
+	ScopeExists          = errors.New("scope already exists")
+	ScopeNotFound        = errors.New("scope not found")
+	ScopeInUse           = errors.New("scope is allowed by apps")
+	BuiltInScopeReadonly = errors.New("built-in scope can not be changed")
+)
+
+func isBuiltInScope(name string) bool {
+	for _, scope := range BuiltInScopes {
+		if scope.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetScopeList built-in scopes followed by custom scopes
+func GetScopeList() ([]*database.Scope, error) {
+	var scopes []*database.Scope
+	err := database.Instance.Order("name").Find(&scopes).Error
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]*database.Scope{}, BuiltInScopes...), scopes...), nil
+}
+
+// checkScopeName scope token syntax of RFC 6749 section 3.3
+func checkScopeName(name string) error {
+	if name == "" {
+		return InvalidateScopeName
+	}
+	for _, c := range name {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return InvalidateScopeName
+		}
+	}
+	return nil
+}
+
+func CreateScope(name string, description string) (*database.Scope, error) {
+	err := checkScopeName(name)
+	if err != nil {
+		return nil, err
+	}
+	if isBuiltInScope(name) {
+		return nil, ScopeExists
+	}
+	var count int64
+	err = database.Instance.Model(&database.Scope{}).Where("name = ?", name).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ScopeExists
+	}
+	scope := &database.Scope{Name: name, Description: description}
+	err = database.Instance.Create(scope).Error
+	if err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+// RemoveScope remove the custom scope, scopes still allowed by apps can not be removed
+func RemoveScope(name string) error {
+	if isBuiltInScope(name) {
+		return BuiltInScopeReadonly
+	}
+	scope := &database.Scope{}
+	err := database.Instance.Where("name = ?", name).First(scope).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ScopeNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var apps []*database.App
+	err = database.Instance.Find(&apps).Error
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if HasScope(app.AllowedScopes, name) || HasScope(app.ClientCredentialsScopes, name) {
+			return ScopeInUse
+		}
+	}
+	return database.Instance.Unscoped().Delete(scope).Error
+}
+
+// checkScopes every scope must be registered
+func checkScopes(scopes []string) error {
+	for _, name := range scopes {
+		if isBuiltInScope(name) {
+			continue
+		}
+		var count int64
+		err := database.Instance.Model(&database.Scope{}).Where("name = ?", name).Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return InvalidateScope
+		}
+	}
+	return nil
+}
+
+// GetAllowedScopes scopes the app may request for users, apps without declaration may request built-in scopes
+func GetAllowedScopes(app *database.App) []string {
+	if app.AllowedScopes == "" {
+		names := make([]string, 0, len(BuiltInScopes))
+		for _, scope := range BuiltInScopes {
+			names = append(names, scope.Name)
+		}
+		return names
+	}
+	return strings.Fields(app.AllowedScopes)
+}
+
+// ResolveScope check the requested scope is allowed for the app, return the normalized scope
+func ResolveScope(app *database.App, requested string) (string, error) {
+	allowed := strings.Join(GetAllowedScopes(app), " ")
+	granted := make([]string, 0)
+	for _, name := range strings.Fields(requested) {
+		if !HasScope(allowed, name) {
+			return "", InvalidateScope
+		}
+		if !HasScope(strings.Join(granted, " "), name) {
+			granted = append(granted, name)
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// narrowScope scope requested on refresh must not exceed the original grant (RFC 6749 section 6)
+func narrowScope(original string, requested string) (string, error) {
+	if requested == "" {
+		return original, nil
+	}
+	granted := make([]string, 0)
+	for _, name := range strings.Fields(requested) {
+		if !HasScope(original, name) {
+			return "", InvalidateScope
+		}
+		granted = append(granted, name)
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// isRefreshTokenAllowed OpenID Connect requests only get a refresh token with offline_access
+func isRefreshTokenAllowed(scope string) bool {
+	return !HasScope(scope, ScopeOpenId) || HasScope(scope, ScopeOfflineAccess)
+}
+
+// GetScopesByNames definitions of the space separated scope, unregistered names only carry the name
+func GetScopesByNames(scope string) ([]*database.Scope, error) {
+	names := strings.Fields(scope)
+	if len(names) == 0 {
+		return []*database.Scope{}, nil
+	}
+	var custom []*database.Scope
+	err := database.Instance.Where("name IN ?", names).Find(&custom).Error
+	if err != nil {
+		return nil, err
+	}
+	scopes := make([]*database.Scope, 0, len(names))
+	for _, name := range names {
+		var found *database.Scope
+		for _, item := range append(append([]*database.Scope{}, BuiltInScopes...), custom...) {
+			if item.Name == name {
+				found = item
+				break
+			}
+		}
+		if found == nil {
+			found = &database.Scope{Name: name}
+		}
+		scopes = append(scopes, found)
+	}
+	return scopes, nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/rs/xid"
+	"gorm.io/gorm"
+)
+
+var SessionExpired = errors.New("session expired")
+
+// hashOpaqueToken opaque tokens such as the session cookie are only stored as hash
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession start a single sign-on session for the user who just entered the password, return the cookie value
+func CreateSession(userId uint) (string, *database.Session, error) {
+	token, err := newRequestId()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	session := &database.Session{
+		TokenHash:    hashOpaqueToken(token),
+		Sid:          xid.New().String(),
+		UserId:       &userId,
+		AuthTime:     now,
+		LastActiveAt: now,
+		ExpiresAt:    now.Add(time.Duration(config.Instance.SessionConfig.AbsoluteTimeout) * time.Second),
+	}
+	err = database.Instance.Create(session).Error
+	if err != nil {
+		return "", nil, err
+	}
+	return token, session, nil
+}
+
+// GetSession session of the cookie value with its user, expired sessions are removed.
+// Every successful lookup keeps the session from idling out
+func GetSession(token string) (*database.Session, error) {
+	session := &database.Session{}
+	err := database.Instance.Preload("User").Where("token_hash = ?", hashOpaqueToken(token)).First(session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, SessionExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	idleDeadline := session.LastActiveAt.Add(time.Duration(config.Instance.SessionConfig.IdleTimeout) * time.Second)
+	if session.User == nil || now.After(session.ExpiresAt) || now.After(idleDeadline) {
+		database.Instance.Unscoped().Delete(session)
+		return nil, SessionExpired
+	}
+	err = database.Instance.Model(session).Update("last_active_at", now).Error
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// RemoveSession end the session of the cookie value
+func RemoveSession(token string) error {
+	return database.Instance.Unscoped().Where("token_hash = ?", hashOpaqueToken(token)).Delete(&database.Session{}).Error
+}
+
+// addSessionApp remember the app signed in through the session, so that it is notified on logout
+func addSessionApp(sid string, appId uint) error {
+	session := &database.Session{}
+	err := database.Instance.Where("sid = ?", sid).First(session).Error
+	if err != nil {
+		return err
+	}
+	var count int64
+	err = database.Instance.Model(&database.SessionApp{}).Where("session_id = ? AND app_id = ?", session.ID, appId).Count(&count).Error
+	if err != nil || count > 0 {
+		return err
+	}
+	return database.Instance.Create(&database.SessionApp{SessionId: session.ID, AppId: appId}).Error
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/projectxpolaris/youauth/database"
+	"github.com/rs/xid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	TokenRevoked          = errors.New("token revoked")
+	TokenNotOwnedByClient = errors.New("token was not issued to the client")
+	RefreshTokenReused    = errors.New("refresh token already used")
+)
+
+// saveIssuedToken persist the token identifier so that the token can be revoked before it expires
+func saveIssuedToken(claims *AuthClaim, userId *uint, appId *uint, authCodeId *uint, familyId string) error {
+	return database.Instance.Create(&database.IssuedToken{
+		Jti:        claims.Id,
+		Type:       claims.Type,
+		UserId:     userId,
+		AppId:      appId,
+		AuthCodeId: authCodeId,
+		FamilyId:   familyId,
+		ExpiresAt:  time.Unix(claims.ExpiresAt, 0),
+	}).Error
+}
+
+func newTokenFamily() string {
+	return xid.New().String()
+}
+
+// getIssuedToken record of the token, nil if the token is not tracked
+func getIssuedToken(jti string) (*database.IssuedToken, error) {
+	issued := &database.IssuedToken{}
+	err := database.Instance.Where("jti = ?", jti).First(issued).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+func isTokenRevoked(jti string) (bool, error) {
+	var count int64
+	err := database.Instance.Model(&database.IssuedToken{}).Where("jti = ? AND (revoked = ? OR rotated = ?)", jti, true, true).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeTokensOfAuthCode revoke every token issued from the auth code, including tokens refreshed from them
+func RevokeTokensOfAuthCode(authCodeId uint) error {
+	return database.Instance.Model(&database.IssuedToken{}).Where("auth_code_id = ?", authCodeId).Update("revoked", true).Error
+}
+
+// newUntrackedIssuedToken record for a token issued before tokens were tracked, owner is resolved from the claims
+func newUntrackedIssuedToken(claims *AuthClaim) (*database.IssuedToken, error) {
+	issued := &database.IssuedToken{
+		Jti:       claims.trackingId(),
+		Type:      claims.Type,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+	if username := claims.GetUsername(); username != "" {
+		user, err := GetUserByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		issued.UserId = &user.ID
+	}
+	if clientId := claims.GetClientId(); clientId != "" {
+		app, err := GetAppByAppId(clientId)
+		if err != nil {
+			return nil, err
+		}
+		issued.AppId = &app.ID
+	}
+	return issued, nil
+}
+
+// RevokeTokensOfUser revoke every token issued to the user, used when the credential of the user changed
+func RevokeTokensOfUser(userId uint) error {
+	return database.Instance.Model(&database.IssuedToken{}).Where("user_id = ?", userId).Update("revoked", true).Error
+}
+
+// RevokeToken revoke the token on behalf of the app (RFC 7009).
+// Invalid or expired tokens are ignored, revoking a refresh token also revokes the tokens of the same grant
+func RevokeToken(tokenString string, app *database.App) error {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil
+	}
+	if claims.Type != "access" && claims.Type != "refresh" {
+		return nil
+	}
+	if claims.GetClientId() != app.AppId {
+		return TokenNotOwnedByClient
+	}
+	issued, err := getIssuedToken(claims.trackingId())
+	if err != nil {
+		return err
+	}
+	if issued == nil {
+		issued, err = newUntrackedIssuedToken(claims)
+		if err != nil {
+			return err
+		}
+		issued.Revoked = true
+		return database.Instance.Create(issued).Error
+	}
+	if claims.Type == "refresh" && issued.FamilyId != "" {
+		return RevokeTokenFamily(issued.FamilyId)
+	}
+	return database.Instance.Model(issued).Update("revoked", true).Error
+}
+
+// RevokeTokenFamily revoke the refresh tokens of the family and the access tokens issued with them
+func RevokeTokenFamily(familyId string) error {
+	return database.Instance.Model(&database.IssuedToken{}).Where("family_id = ?", familyId).Update("revoked", true).Error
+}
+
+// rotateRefreshToken mark the refresh token as used, a token can only be rotated once.
+// Presenting a rotated token again revokes the whole family
+func rotateRefreshToken(claims *AuthClaim) (*database.IssuedToken, error) {
+	issued, err := getIssuedToken(claims.trackingId())
+	if err != nil {
+		return nil, err
+	}
+	if issued == nil {
+		// refresh token issued before tokens were tracked, it is exchanged once for a tracked family
+		issued, err = newUntrackedIssuedToken(claims)
+		if err != nil {
+			return nil, err
+		}
+		issued.FamilyId = newTokenFamily()
+		issued.Rotated = true
+		err = database.Instance.Create(issued).Error
+		if err != nil {
+			return nil, err
+		}
+		return issued, nil
+	}
+	if issued.Revoked {
+		return nil, TokenRevoked
+	}
+	if !issued.Rotated {
+		result := database.Instance.Model(&database.IssuedToken{}).Where("id = ? AND rotated = ?", issued.ID, false).Update("rotated", true)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected > 0 {
+			if issued.FamilyId == "" {
+				issued.FamilyId = newTokenFamily()
+				err = database.Instance.Model(issued).Update("family_id", issued.FamilyId).Error
+				if err != nil {
+					return nil, err
+				}
+			}
+			return issued, nil
+		}
+		// rotated by a concurrent request
+	}
+	Logger.WithFields(log.Fields{
+		"jti":    issued.Jti,
+		"family": issued.FamilyId,
+		"user":   issued.UserId,
+		"app":    issued.AppId,
+	}).Warn("refresh token reuse detected, token family revoked")
+	if issued.FamilyId != "" {
+		err = RevokeTokenFamily(issued.FamilyId)
+	} else {
+		err = database.Instance.Model(issued).Update("revoked", true).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, RefreshTokenReused
+}
@@ -1,10 +1,16 @@
 package service
 
 import (
+	"errors"
+	"time"
+
+	"github.com/projectxpolaris/youauth/config"
 	"github.com/projectxpolaris/youauth/database"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var PermissionDenied = errors.New("permission denied")
+
 type UserQueryBuilder struct {
 	Ids        []string `hsource:"query" hname:"ids"`
 	NameSearch string   `hsource:"query" hname:"search"`
@@ -60,7 +66,11 @@ func GetUserByUsername(username string) (*database.User, error) {
 	return user, nil
 }
 func DeleteUser(id string) error {
-	return database.Instance.Unscoped().Model(&database.User{}).Where("id = ?", id).Delete(&database.User{}).Error
+	err := database.Instance.Unscoped().Model(&database.User{}).Where("id = ?", id).Delete(&database.User{}).Error
+	if err != nil {
+		return err
+	}
+	return database.Instance.Model(&database.IssuedToken{}).Where("user_id = ?", id).Update("revoked", true).Error
 }
 
 func ChangePassword(id uint, oldPassword, password string) error {
@@ -81,6 +91,8 @@ func ChangePassword(id uint, oldPassword, password string) error {
 		return err
 	}
 	user.Password = string(rawPassword)
+	now := time.Now()
+	user.TokensValidAfter = &now
 	err = database.Instance.Save(&user).Error
 	if err != nil {
 		return err
@@ -89,5 +101,19 @@ func ChangePassword(id uint, oldPassword, password string) error {
 	if err != nil {
 		return err
 	}
+	err = RevokeTokensOfUser(id)
+	if err != nil {
+		return err
+	}
 	return nil
 }
+
+// IsAdmin whether the user is listed in the admins config
+func IsAdmin(user *database.User) bool {
+	for _, username := range config.Instance.Admins {
+		if username == user.Username {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+var (
+	UnsupportedKeyType = errors.New("unsupported key type")
+	InvalidateKey      = errors.New("invalid key")
+)
+
+// JWK public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+func NewJWK(publicKey crypto.PublicKey, kid string, alg string) (*JWK, error) {
+	jwk := &JWK{
+		Kid: kid,
+		Use: "sig",
+		Alg: alg,
+	}
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return nil, UnsupportedKeyType
+		}
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return nil, UnsupportedKeyType
+	}
+	return jwk, nil
+}
+
+// Thumbprint JWK thumbprint of the key (RFC 7638)
+func (k *JWK) Thumbprint() (string, error) {
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	default:
+		return "", UnsupportedKeyType
+	}
+	raw, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// ParseJWKSet parse a JWK set, keys that are not signature keys are dropped
+func ParseJWKSet(raw []byte) (*JWKSet, error) {
+	set := &JWKSet{}
+	err := json.Unmarshal(raw, set)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*JWK, 0)
+	for _, key := range set.Keys {
+		if key == nil || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		if _, err = key.PublicKey(); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	set.Keys = keys
+	return set, nil
+}
+
+// Find keys usable for the kid, a token without kid can be verified by any key of the set
+func (s *JWKSet) Find(kid string) []*JWK {
+	keys := make([]*JWK, 0)
+	for _, key := range s.Keys {
+		if kid == "" || key.Kid == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// PublicKey the public key of the JWK, in the types the jwt signing methods expect
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, InvalidateKey
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, UnsupportedKeyType
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, InvalidateKey
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, UnsupportedKeyType
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, InvalidateKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, UnsupportedKeyType
+}